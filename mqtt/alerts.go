@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// batteryHysteresis is how many percent above the threshold the battery
+	// must recover before a low-battery alert clears.
+	batteryHysteresis = 5
+	// temperatureHysteresis is how many degrees below the threshold the
+	// temperature must fall before a high-temperature alert clears.
+	temperatureHysteresis = 2.0
+)
+
+// Alert kinds.
+const (
+	AlertBattery     = "battery"
+	AlertOffline     = "offline"
+	AlertTemperature = "temperature"
+)
+
+// AlertOptions configures threshold alerts. Zero values disable a check.
+type AlertOptions struct {
+	BatteryBelow     int64
+	OfflineAfter     time.Duration
+	TemperatureAbove float64
+	WebhookURL       string
+	// OfflineDwell only reports a device going offline or coming back
+	// once the new state has lasted this long, so a node on a marginal
+	// link does not flap. Zero reports every transition immediately.
+	OfflineDwell time.Duration
+}
+
+// Alert is sent when a device crosses a threshold (Active) or recovers.
+type Alert struct {
+	DeviceID  string    `json:"device_id"`
+	Kind      string    `json:"kind"`
+	Active    bool      `json:"active"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// AlertMessage is sent over WebSocket to browsers.
+type AlertMessage struct {
+	Type string `json:"type"`
+	Data Alert  `json:"data"`
+}
+
+type alertKey struct {
+	id   string
+	kind string
+}
+
+// Alerter evaluates per-device thresholds and sends edge-triggered alerts to
+// WebSocket clients and an optional webhook.
+type Alerter struct {
+	opts   AlertOptions
+	cm     *ConnectionManager
+	client *http.Client
+
+	mu      sync.Mutex
+	active  map[alertKey]bool
+	pending map[alertKey]*time.Timer
+
+	onAlert []func(Alert)
+}
+
+func NewAlerter(opts AlertOptions, cm *ConnectionManager) *Alerter {
+	return &Alerter{
+		opts:    opts,
+		cm:      cm,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		active:  make(map[alertKey]bool),
+		pending: make(map[alertKey]*time.Timer),
+	}
+}
+
+// OnAlert registers fn to be called with every alert transition. It must be
+// called before devices are checked.
+func (a *Alerter) OnAlert(fn func(Alert)) {
+	a.onAlert = append(a.onAlert, fn)
+}
+
+// CheckDevice evaluates the battery threshold and clears any offline alert
+// after a device update.
+func (a *Alerter) CheckDevice(v DeviceView) {
+	if a.opts.BatteryBelow > 0 && v.BatteryLevel > 0 {
+		threshold := float64(a.opts.BatteryBelow)
+		level := float64(v.BatteryLevel)
+		switch {
+		case level < threshold:
+			a.set(v.ID, AlertBattery, true, level, threshold)
+		case level >= threshold+batteryHysteresis:
+			a.set(v.ID, AlertBattery, false, level, threshold)
+		}
+	}
+	if a.opts.OfflineAfter > 0 {
+		a.setPresence(v.ID, false, 0)
+	}
+}
+
+// CheckTelemetry evaluates the temperature threshold for environment
+// telemetry.
+func (a *Alerter) CheckTelemetry(id string, t TelemetryPayload) {
+	if a.opts.TemperatureAbove == 0 || t.Temperature == nil {
+		return
+	}
+	temp := *t.Temperature
+	switch {
+	case temp > a.opts.TemperatureAbove:
+		a.set(id, AlertTemperature, true, temp, a.opts.TemperatureAbove)
+	case temp <= a.opts.TemperatureAbove-temperatureHysteresis:
+		a.set(id, AlertTemperature, false, temp, a.opts.TemperatureAbove)
+	}
+}
+
+// Run checks for devices that have been silent longer than OfflineAfter every
+// interval until ctx is cancelled.
+func (a *Alerter) Run(ctx context.Context, interval time.Duration, list func(context.Context) ([]DeviceView, error)) {
+	if a.opts.OfflineAfter <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			listCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			views, err := list(listCtx)
+			cancel()
+			if err != nil {
+				slog.Error("failed to list devices for alerts", "err", err)
+				continue
+			}
+			for _, v := range views {
+				silent := now.Sub(v.LastSeen)
+				if silent > a.opts.OfflineAfter {
+					a.setPresence(v.ID, true, silent.Seconds())
+				}
+			}
+		}
+	}
+}
+
+// Forget drops the alert state of removed devices, cancelling pending
+// offline transitions, without sending alerts.
+func (a *Alerter) Forget(ids []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, id := range ids {
+		for _, kind := range []string{AlertBattery, AlertOffline, AlertTemperature} {
+			key := alertKey{id: id, kind: kind}
+			delete(a.active, key)
+			if t, ok := a.pending[key]; ok {
+				t.Stop()
+				delete(a.pending, key)
+			}
+		}
+	}
+}
+
+// setPresence sets the offline alert of a device after OfflineDwell: the
+// change is only reported if no opposite change arrives in the meantime.
+func (a *Alerter) setPresence(id string, offline bool, silent float64) {
+	threshold := a.opts.OfflineAfter.Seconds()
+	if a.opts.OfflineDwell <= 0 {
+		a.set(id, AlertOffline, offline, silent, threshold)
+		return
+	}
+
+	key := alertKey{id: id, kind: AlertOffline}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active[key] == offline {
+		// Back to the reported state before the dwell ended.
+		if t, ok := a.pending[key]; ok {
+			t.Stop()
+			delete(a.pending, key)
+		}
+		return
+	}
+	if _, ok := a.pending[key]; ok {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(a.opts.OfflineDwell, func() {
+		a.mu.Lock()
+		if a.pending[key] != t {
+			a.mu.Unlock()
+			return
+		}
+		delete(a.pending, key)
+		a.mu.Unlock()
+		if offline {
+			silent += a.opts.OfflineDwell.Seconds()
+		}
+		a.set(id, AlertOffline, offline, silent, threshold)
+	})
+	a.pending[key] = t
+}
+
+// set records the alert state and notifies only when it changes.
+func (a *Alerter) set(id, kind string, active bool, value, threshold float64) {
+	key := alertKey{id: id, kind: kind}
+	a.mu.Lock()
+	if a.active[key] == active {
+		a.mu.Unlock()
+		return
+	}
+	if active {
+		a.active[key] = true
+	} else {
+		delete(a.active, key)
+	}
+	a.mu.Unlock()
+
+	alert := Alert{
+		DeviceID:  id,
+		Kind:      kind,
+		Active:    active,
+		Value:     value,
+		Threshold: threshold,
+		Time:      time.Now().UTC(),
+	}
+	slog.Info("device alert", "id", id, "kind", kind, "active", active, "value", value, "threshold", threshold)
+	a.notify(alert)
+}
+
+func (a *Alerter) notify(alert Alert) {
+	msg, err := newWSMessage(AlertMessage{Type: "alert", Data: alert})
+	if err != nil {
+		slog.Error("failed to marshal alert", "err", err)
+		return
+	}
+
+	a.cm.BroadcastAll(frameEvent, msg)
+	for _, fn := range a.onAlert {
+		fn(alert)
+	}
+
+	if a.opts.WebhookURL == "" {
+		return
+	}
+	go func() {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			slog.Error("failed to marshal alert webhook", "err", err)
+			return
+		}
+		resp, err := a.client.Post(a.opts.WebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Warn("alert webhook failed", "err", err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.Warn("alert webhook returned error", "status", resp.StatusCode)
+		}
+	}()
+}
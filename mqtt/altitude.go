@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+)
+
+// AltitudeUnit is the unit reported position altitudes are read in.
+type AltitudeUnit string
+
+const (
+	// AltitudeAuto reads altitudes as meters, except whole numbers above
+	// altitudeAutoMillimeters, which are taken as millimeters.
+	AltitudeAuto AltitudeUnit = "auto"
+	// AltitudeMeters reads every altitude as meters.
+	AltitudeMeters AltitudeUnit = "m"
+	// AltitudeMillimeters reads every altitude as millimeters.
+	AltitudeMillimeters AltitudeUnit = "mm"
+)
+
+const (
+	// altitudeAutoMillimeters is above any ground node, so a whole number
+	// beyond it is far more likely millimeters than meters. Balloon and
+	// aircraft deployments should set the unit explicitly.
+	altitudeAutoMillimeters = 10000
+	// Altitudes outside this range in meters are discarded as implausible.
+	minAltitude = -500
+	maxAltitude = 50000
+)
+
+// normalizeAltitude converts a reported altitude to meters using unit. An
+// implausible result is discarded and reported as 0, the value used for an
+// unknown altitude.
+func normalizeAltitude(alt float64, unit AltitudeUnit) float64 {
+	meters := alt
+	switch unit {
+	case AltitudeMillimeters:
+		meters = alt / 1000
+	case AltitudeAuto:
+		if math.Abs(alt) > altitudeAutoMillimeters && alt == math.Trunc(alt) {
+			meters = alt / 1000
+		}
+	}
+	if meters < minAltitude || meters > maxAltitude {
+		slog.Debug("discarding implausible altitude", "altitude", alt, "unit", unit)
+		return 0
+	}
+	return meters
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizeAltitude(t *testing.T) {
+	tests := []struct {
+		name string
+		alt  float64
+		unit AltitudeUnit
+		want float64
+	}{
+		{"meters", 300, AltitudeMeters, 300},
+		{"meters below sea level", -20, AltitudeMeters, -20},
+		{"meters too high", 60000, AltitudeMeters, 0},
+		{"meters too low", -600, AltitudeMeters, 0},
+		{"millimeters", 300000, AltitudeMillimeters, 300},
+		{"small millimeters", 500, AltitudeMillimeters, 0.5},
+		{"auto meters", 300, AltitudeAuto, 300},
+		{"auto fractional meters", 10500.5, AltitudeAuto, 10500.5},
+		{"auto whole millimeters", 300000, AltitudeAuto, 300},
+		{"auto negative millimeters", -20000, AltitudeAuto, -20},
+		{"auto at threshold", 10000, AltitudeAuto, 10000},
+		{"auto implausible", 1e11, AltitudeAuto, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeAltitude(tt.alt, tt.unit); got != tt.want {
+				t.Errorf("normalizeAltitude(%v, %q) = %v, want %v", tt.alt, tt.unit, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,19 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
+	"database/sql"
 	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coder/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const oneYearCacheControl = "public, max-age=31536000"
 
+// snapshotCacheControl matches snapshotCacheTTL.
+const snapshotCacheControl = "public, max-age=30"
+
+// wsPingInterval is how often WebSocket clients are pinged, so a browser
+// that dies without closing its connection is noticed well before the read
+// timeout.
+const wsPingInterval = 20 * time.Second
+
 var (
 	//go:embed dist/*
 	distFiles embed.FS
@@ -25,17 +44,62 @@ var (
 	cacheBust = time.Now().Format("20060102150405")
 )
 
+// AppOptions configures the HTTP server.
+type AppOptions struct {
+	// AdminToken is the bearer token required by admin endpoints. When
+	// empty, admin endpoints are disabled.
+	AdminToken string
+	// ReadOnly disables every mutating endpoint regardless of the token.
+	// The WebSocket feed and read APIs stay available.
+	ReadOnly bool
+	// FilterTTL resets filters set over the WebSocket command protocol to
+	// the full feed unless renewed within this long. Zero never expires.
+	FilterTTL time.Duration
+	// FilterThrottle is the minimum interval between snapshots sent to one
+	// client after it changes its filter. Changes within the interval are
+	// coalesced into a single snapshot. Zero sends one per change.
+	FilterThrottle time.Duration
+	// ExcludeOffline leaves offline devices out of device lists and
+	// WebSocket updates unless a request asks for them with
+	// ?include_offline=true.
+	ExcludeOffline bool
+	// SnapshotURL is a static map service used to render
+	// /api/snapshot.png. When empty, devices are drawn as dots on a plain
+	// background.
+	SnapshotURL string
+	// SnapshotRetries is how many more times loading a new WebSocket
+	// client's initial snapshot is attempted after a failure, waiting
+	// SnapshotBackoff before the first retry and twice as long before each
+	// further one. The connection is closed if every attempt fails, so the
+	// client reconnects instead of showing no devices.
+	SnapshotRetries int
+	SnapshotBackoff time.Duration
+	// Feed serves recent device events at /api/feed.atom. Nil disables the
+	// endpoint.
+	Feed *EventFeed
+	// BrokerInfo is served at /api/broker/info. Nil disables the endpoint.
+	BrokerInfo *BrokerInfo
+}
+
 type App struct {
 	cm         *ConnectionManager
 	subscriber *Subscriber
 	addr       string
+	opts       AppOptions
+	snapshots  *SnapshotRenderer
 }
 
-func NewApp(addr string, cm *ConnectionManager, sub *Subscriber) *App {
-	return &App{addr: addr, cm: cm, subscriber: sub}
+func NewApp(addr string, cm *ConnectionManager, sub *Subscriber, opts AppOptions) *App {
+	return &App{
+		addr:       addr,
+		cm:         cm,
+		subscriber: sub,
+		opts:       opts,
+		snapshots:  NewSnapshotRenderer(opts.SnapshotURL),
+	}
 }
 
-func (a *App) Run() error {
+func (a *App) Run(ctx context.Context) error {
 	mux := http.NewServeMux()
 
 	// Static assets
@@ -47,6 +111,38 @@ func (a *App) Run() error {
 
 	// WebSocket
 	mux.HandleFunc("GET /ws", a.handleWebSocket)
+	mux.HandleFunc("GET /healthz", a.handleHealthz)
+	mux.HandleFunc("GET /readyz", a.handleReadyz)
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	// API
+	mux.HandleFunc("GET /api/devices", a.handleDevices)
+	mux.HandleFunc("GET /api/devices.kml", a.handleDevicesKML)
+	mux.HandleFunc("GET /api/devices.bin", a.handleDevicesBinary)
+	mux.HandleFunc("GET /api/snapshot.png", a.handleSnapshot)
+	mux.HandleFunc("GET /api/feed.atom", a.handleFeed)
+	mux.HandleFunc("GET /api/broker/info", a.handleBrokerInfo)
+	mux.HandleFunc("GET /api/messages", a.handleMessages)
+	mux.HandleFunc("GET /api/devices/{id}", a.handleDevice)
+	mux.HandleFunc("GET /api/devices/{id}/telemetry", a.handleDeviceTelemetry)
+	mux.HandleFunc("GET /api/devices/{id}/track", a.handleDeviceTrack)
+	mux.HandleFunc("GET /api/devices/{id}/gateways", a.handleDeviceGateways)
+	mux.HandleFunc("GET /api/devices/{id}/route", a.handleDeviceRoute)
+	mux.HandleFunc("GET /api/devices/{id}/full", a.handleDeviceRecord)
+	mux.HandleFunc("GET /api/devices/{id}/status", a.handleDeviceStatus)
+
+	// Grafana JSON datasource
+	mux.HandleFunc("GET /api/grafana/{$}", a.handleGrafanaTest)
+	mux.HandleFunc("POST /api/grafana/search", a.handleGrafanaSearch)
+	mux.HandleFunc("POST /api/grafana/query", a.handleGrafanaQuery)
+
+	// Admin API
+	mux.Handle("PUT /api/devices/{id}/position", a.requireAdmin(http.HandlerFunc(a.handleSetPosition)))
+	mux.Handle("DELETE /api/devices/{id}/position", a.requireAdmin(http.HandlerFunc(a.handleClearPosition)))
+	mux.Handle("PUT /api/devices/{id}/tags", a.requireAdmin(http.HandlerFunc(a.handleSetTags)))
+
+	// Anything else under /api/ would otherwise fall through to the index.
+	mux.HandleFunc("/api/", apiNotFound(mux))
 
 	// Index
 	mux.HandleFunc("/", a.handleIndex)
@@ -57,8 +153,23 @@ func (a *App) Run() error {
 		Handler:           mux,
 	}
 
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
 	slog.Info("HTTP server started", "addr", "http://"+a.addr)
-	return server.ListenAndServe()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("shutting down HTTP server")
+	// WebSocket connections are hijacked, so Shutdown does not close them.
+	a.cm.CloseAll()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
 
 func (a *App) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -66,14 +177,463 @@ func (a *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	d := struct{ CacheBust string }{CacheBust: cacheBust}
-	if err := templates.ExecuteTemplate(w, "index.html.tmpl", d); err != nil {
+	// Render into a buffer so a failure does not send half a page.
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "index.html.tmpl", indexData{CacheBust: cacheBust}); err != nil {
 		slog.Error("template error", "err", err)
 		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
+
+// apiNotFound answers API requests no route of mux matches with a JSON error,
+// as API clients expect, instead of the index's plain-text 404. Requests for
+// an endpoint with a method it does not accept get 405 and an Allow header.
+func apiNotFound(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			if method == r.Method {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "/api/" && pattern != "/" && pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown API endpoint"})
+	}
+}
+
+// indexData is the data index.html.tmpl is rendered with.
+type indexData struct {
+	CacheBust string
+}
+
+// checkTemplates renders every template with the data it is served with, so
+// that execution errors, which parsing does not catch, surface at startup
+// rather than on the first request.
+func checkTemplates() error {
+	for _, t := range templates.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := t.Execute(io.Discard, indexData{CacheBust: cacheBust}); err != nil {
+			return fmt.Errorf("template %s: %w", t.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (a *App) handleDevices(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := filterFromQuery(q, a.opts.ExcludeOffline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	less, err := deviceSort(q.Get("sort"), q.Get("order"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// ?online=true lists only online devices, ?online=false only offline
+	// ones.
+	var online *bool
+	if v := q.Get("online"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid online %q: want true or false", v), http.StatusBadRequest)
+			return
+		}
+		online = &b
+	}
+
+	views, err := a.subscriber.ListViews(r.Context())
+	if err != nil {
+		slog.Error("failed to list devices", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	views = filter.apply(views)
+	if online != nil {
+		views = slices.DeleteFunc(views, func(v DeviceView) bool { return v.Online != *online })
+	}
+	if less != nil {
+		slices.SortStableFunc(views, less)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// statusResponse is the body of the health check endpoints.
+type statusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleHealthz is a liveness probe; it does not touch the database.
+func (a *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
+}
+
+// handleReadyz is a readiness probe that fails while the database does not
+// answer.
+func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := a.subscriber.Ping(ctx); err != nil {
+		slog.Warn("readiness check failed", "err", err)
+		writeJSON(w, http.StatusServiceUnavailable, statusResponse{Status: "unavailable", Error: "database unreachable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
+}
+
+// errorResponse is the JSON body of errors from endpoints returning JSON.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// handleDevice returns one device by node ID, e.g. for permalinks. IDs are
+// matched case-insensitively.
+func (a *App) handleDevice(w http.ResponseWriter, r *http.Request) {
+	id := strings.ToLower(r.PathValue("id"))
+	if !validNodeID(id) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid device ID: want !xxxxxxxx"})
+		return
+	}
+	view, err := a.subscriber.View(r.Context(), id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "device not found"})
+	case err != nil:
+		slog.Error("failed to load device", "id", id, "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, view)
+	}
+}
+
+// handleDeviceStatus reports the parse and validation failures of a device.
+func (a *App) handleDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	id := strings.ToLower(r.PathValue("id"))
+	if !validNodeID(id) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid device ID: want !xxxxxxxx"})
+		return
+	}
+	status, err := a.subscriber.DeviceStatus(r.Context(), id)
+	switch {
+	case errors.Is(err, errDeviceErrorsDisabled):
+		http.NotFound(w, r)
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "device not found"})
+	case err != nil:
+		slog.Error("failed to load device", "id", id, "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+func (a *App) handleDevicesKML(w http.ResponseWriter, r *http.Request) {
+	filter, err := filterFromQuery(r.URL.Query(), a.opts.ExcludeOffline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	views, err := a.subscriber.ListViews(r.Context())
+	if err != nil {
+		slog.Error("failed to list devices", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	if err := writeKML(w, filter.apply(views)); err != nil {
+		slog.Warn("failed to write KML", "err", err)
+	}
+}
+
+func (a *App) handleDevicesBinary(w http.ResponseWriter, r *http.Request) {
+	filter, err := filterFromQuery(r.URL.Query(), a.opts.ExcludeOffline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	views, err := a.subscriber.ListViews(r.Context())
+	if err != nil {
+		slog.Error("failed to list devices", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := writeDevicesBinary(w, filter.apply(views)); err != nil {
+		slog.Warn("failed to write binary device list", "err", err)
+	}
+}
+
+func (a *App) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	filter, err := filterFromQuery(r.URL.Query(), a.opts.ExcludeOffline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, contentType, err := a.snapshots.Render(r.Context(), filter.room(), func(ctx context.Context) ([]DeviceView, error) {
+		views, err := a.subscriber.ListViews(ctx)
+		return filter.apply(views), err
+	})
+	if err != nil {
+		slog.Error("failed to render snapshot", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", snapshotCacheControl)
+	_, _ = w.Write(data)
+}
+
+// handleMessages returns the most recent text messages, newest first.
+func (a *App) handleMessages(w http.ResponseWriter, r *http.Request) {
+	limit := int64(defaultMessagesLimit)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > maxMessagesLimit {
+			http.Error(w, fmt.Sprintf("invalid limit %q: want 1 to %d", v, maxMessagesLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	messages, err := a.subscriber.RecentMessages(r.Context(), limit)
+	if err != nil {
+		slog.Error("failed to list text messages", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// handleBrokerInfo describes the embedded MQTT broker.
+func (a *App) handleBrokerInfo(w http.ResponseWriter, r *http.Request) {
+	if a.opts.BrokerInfo == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.opts.BrokerInfo)
+}
+
+func (a *App) handleFeed(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Feed == nil {
+		http.NotFound(w, r)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	self := scheme + "://" + r.Host + r.URL.Path
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	if err := writeAtom(w, self, a.opts.Feed.Events(), time.Now()); err != nil {
+		slog.Warn("failed to write Atom feed", "err", err)
+	}
+}
+
+func (a *App) handleDeviceTelemetry(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, err := parseSince(q.Get("since"), 24*time.Hour, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if v := q.Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "invalid step", http.StatusBadRequest)
+			return
+		}
+	}
+
+	points, err := a.subscriber.TelemetryHistory(r.Context(), r.PathValue("id"), since, step)
+	if err != nil {
+		slog.Error("failed to load telemetry history", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+// handleDeviceTrack returns the positions recorded since ?since= as GeoJSON.
+func (a *App) handleDeviceTrack(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	since, err := parseSince(r.URL.Query().Get("since"), 24*time.Hour, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	positions, err := a.subscriber.Track(r.Context(), id, since)
+	if err != nil {
+		slog.Error("failed to load position history", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, trackGeoJSON(id, positions))
+}
+
+// handleGrafanaTest answers the datasource connection test.
+func (a *App) handleGrafanaTest(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, grafanaMetrics)
+}
+
+func (a *App) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var q grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	for _, t := range q.Targets {
+		if !validGrafanaTarget(t.Target) {
+			http.Error(w, "unknown target "+t.Target, http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := a.subscriber.GrafanaQuery(r.Context(), q, time.Now())
+	if err != nil {
+		slog.Error("failed to answer Grafana query", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// positionRequest is the body of PUT /api/devices/{id}/position.
+type positionRequest struct {
+	Lat *float64 `json:"lat"`
+	Lon *float64 `json:"lon"`
+	Alt float64  `json:"alt"`
+}
+
+func (a *App) handleSetPosition(w http.ResponseWriter, r *http.Request) {
+	var req positionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Lat == nil || req.Lon == nil ||
+		*req.Lat < -90 || *req.Lat > 90 || *req.Lon < -180 || *req.Lon > 180 {
+		http.Error(w, "lat and lon are required and must be in range", http.StatusBadRequest)
+		return
+	}
+
+	view, err := a.subscriber.SetPositionOverride(r.Context(), r.PathValue("id"), *req.Lat, *req.Lon, req.Alt)
+	a.writeDevice(w, view, err)
+}
+
+func (a *App) handleClearPosition(w http.ResponseWriter, r *http.Request) {
+	view, err := a.subscriber.ClearPositionOverride(r.Context(), r.PathValue("id"))
+	a.writeDevice(w, view, err)
+}
+
+// tagsRequest is the body of PUT /api/devices/{id}/tags.
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func (a *App) handleSetTags(w http.ResponseWriter, r *http.Request) {
+	id := strings.ToLower(r.PathValue("id"))
+	if !validNodeID(id) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid device ID: want !xxxxxxxx"})
+		return
+	}
+	var req tagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := a.subscriber.SetTags(r.Context(), id, req.Tags)
+	switch {
+	case errors.Is(err, errInvalidTags):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		slog.Error("failed to set device tags", "id", id, "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, tagsRequest{Tags: tags})
+	}
+}
+
+// writeDevice writes the result of an admin update to a single device.
+func (a *App) writeDevice(w http.ResponseWriter, view DeviceView, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "device not found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("failed to update device", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, view)
+	}
+}
+
+func (a *App) handleDeviceGateways(w http.ResponseWriter, r *http.Request) {
+	samples, err := a.subscriber.GatewaySamples(r.Context(), r.PathValue("id"))
+	if err != nil {
+		slog.Error("failed to load gateway samples", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
+
+func (a *App) handleDeviceRoute(w http.ResponseWriter, r *http.Request) {
+	route, err := a.subscriber.Route(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "no route heard", http.StatusNotFound)
+	case err != nil:
+		slog.Error("failed to load route", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, route)
+	}
+}
+
+func (a *App) handleDeviceRecord(w http.ResponseWriter, r *http.Request) {
+	record, err := a.subscriber.DeviceRecord(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "device not found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("failed to load device record", "err", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, record)
 	}
 }
 
 func (a *App) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Clients may follow a channel with ?channel=, a tag with ?tag= and an
+	// area with ?bbox=; they then only receive the matching devices. The
+	// filter can be changed later with a subscribe command. Offline devices
+	// follow ?include_offline= for the whole connection.
+	filter, err := filterFromQuery(r.URL.Query(), a.opts.ExcludeOffline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
 		InsecureSkipVerify: true,
 	})
@@ -90,36 +650,118 @@ func (a *App) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		clientID = r.RemoteAddr
 	}
 
-	a.cm.Add("browsers", conn)
-	defer a.cm.Remove("browsers", conn)
+	client := a.cm.NewClient(conn, clientID)
+	session := newWSSession(a.cm, a.subscriber, client, filter, a.opts.FilterTTL, a.opts.FilterThrottle)
+	defer session.close()
 
-	slog.Info("WebSocket connected", "client", clientID, "total", a.cm.Count())
+	connectedAt := time.Now()
+	slog.Info("WebSocket connected", "client", clientID, "channel", filter.Channel, "tag", filter.Tag, "total", a.cm.Count())
 
-	// Send current device snapshot to the newly connected client.
+	// Queue the current device snapshot for the newly connected client. A
+	// failed write closes the connection in client.run.
 	ctx := r.Context()
-	snapshot, err := a.subscriber.LoadAndBroadcast(ctx)
+	snapshot, err := a.initialSnapshot(ctx, filter)
 	if err != nil {
-		slog.Error("failed to load initial devices", "err", err)
-	} else {
-		writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		if err := conn.Write(writeCtx, websocket.MessageText, snapshot); err != nil {
-			slog.Warn("failed to send initial snapshot", "err", err)
-		}
-		cancel()
+		slog.Error("failed to load initial devices, closing connection", "client", clientID, "err", err)
+		_ = conn.Close(websocket.StatusTryAgainLater, "failed to load devices")
+		return
 	}
+	client.enqueue(frame{kind: frameSnapshot, msg: snapshot})
+	go client.run(ctx)
+
+	// Closing the connection on a failed ping ends the read loop below,
+	// which tears the connection down.
+	pingCtx, stopPing := context.WithCancel(ctx)
+	defer stopPing()
+	go pingClient(pingCtx, conn, clientID)
 
-	// Keep connection alive; read and discard messages.
+	// Keep connection alive and handle client commands.
 	for {
 		readCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
-		_, _, err := conn.Read(readCtx)
+		_, data, err := conn.Read(readCtx)
 		cancel()
 		if err != nil {
-			slog.Info("WebSocket disconnected", "client", clientID)
+			slog.Info("WebSocket disconnected", "client", clientID, "bytes_sent", client.bytesSent(), "frames_dropped", client.framesDropped(), "duration", time.Since(connectedAt).Round(time.Second).String())
 			return
 		}
+		session.handle(ctx, data)
 	}
 }
 
+// pingClient pings conn every wsPingInterval until ctx is cancelled, and
+// closes it when a ping fails or goes unanswered. Pongs are read by the
+// connection's read loop.
+func pingClient(ctx context.Context, conn *websocket.Conn, clientID string) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, wsPingInterval/2)
+		err := conn.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Info("WebSocket ping failed, closing connection", "client", clientID, "err", err)
+				_ = conn.CloseNow()
+			}
+			return
+		}
+	}
+}
+
+// initialSnapshot loads the snapshot for a new client, retrying failures
+// with exponential backoff up to SnapshotRetries times.
+func (a *App) initialSnapshot(ctx context.Context, filter deviceFilter) (*wsMessage, error) {
+	backoff := a.opts.SnapshotBackoff
+	for attempt := 1; ; attempt++ {
+		snapshot, err := a.subscriber.LoadAndBroadcast(ctx, filter)
+		if err == nil || attempt > a.opts.SnapshotRetries {
+			return snapshot, err
+		}
+		slog.Warn("failed to load initial devices, retrying", "attempt", attempt, "backoff", backoff.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write JSON response", "err", err)
+	}
+}
+
+// requireAdmin gates mutating endpoints. Requests are rejected in read-only
+// mode, when no admin token is configured, or without the admin bearer token.
+func (a *App) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.opts.ReadOnly {
+			http.Error(w, "server is read-only", http.StatusForbidden)
+			return
+		}
+		if a.opts.AdminToken == "" {
+			http.Error(w, "admin API disabled", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.opts.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func cacheControlMiddleware(next http.Handler, cacheControl string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", cacheControl)
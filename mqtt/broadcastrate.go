@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// dirtyDevices collects the devices changed since the last flush, so every
+// broadcast is sent from the flush goroutine and none overlap.
+type dirtyDevices struct {
+	mu  sync.Mutex
+	ids map[string]bool
+	all bool
+	// prevChannels are channels changed devices were on before, whose
+	// rooms need the change too.
+	prevChannels map[string]bool
+
+	// wake is signalled on every change for flushing without a rate limit.
+	wake chan struct{}
+}
+
+func newDirtyDevices() *dirtyDevices {
+	return &dirtyDevices{ids: make(map[string]bool), prevChannels: make(map[string]bool), wake: make(chan struct{}, 1)}
+}
+
+// mark records a change of changed, or of many devices when changed is nil.
+// prevChannels are the channels changed was on before the change.
+func (d *dirtyDevices) mark(changed *db.Device, prevChannels ...string) {
+	d.mu.Lock()
+	if changed == nil {
+		d.all = true
+	} else {
+		d.ids[changed.ID] = true
+	}
+	for _, ch := range prevChannels {
+		if ch != "" {
+			d.prevChannels[ch] = true
+		}
+	}
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns and clears the pending changes.
+func (d *dirtyDevices) take() (ids, prevChannels []string, all bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id := range d.ids {
+		ids = append(ids, id)
+	}
+	for ch := range d.prevChannels {
+		prevChannels = append(prevChannels, ch)
+	}
+	all = d.all
+	clear(d.ids)
+	clear(d.prevChannels)
+	d.all = false
+	return ids, prevChannels, all
+}
+
+// StartBroadcastFlush sends the changes collected since the previous flush
+// until ctx is cancelled: at most BroadcastMaxRate times per second if set,
+// otherwise as soon as they are made. A single changed device goes out as a
+// delta, more as one snapshot. Flushing from one goroutine keeps snapshots
+// in order, so a stale one never follows a fresh one. The returned channel
+// is closed once the goroutine has stopped.
+func (s *Subscriber) StartBroadcastFlush(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wake := s.dirty.wake
+		var tick <-chan time.Time
+		if s.opts.BroadcastMaxRate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / s.opts.BroadcastMaxRate))
+			defer ticker.Stop()
+			wake, tick = nil, ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-tick:
+			}
+			flushCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			s.flushBroadcasts(flushCtx)
+			cancel()
+		}
+	}()
+	return done
+}
+
+func (s *Subscriber) flushBroadcasts(ctx context.Context) {
+	ids, prevChannels, all := s.dirty.take()
+	if len(ids) == 0 && !all {
+		return
+	}
+
+	// Without connected clients nothing is loaded or marshalled. A client
+	// joining afterwards is added to its room before its initial snapshot
+	// is loaded, so the snapshot covers any change skipped here.
+	if s.cm.Count() == 0 && s.socket.Count() == 0 {
+		return
+	}
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "err", err)
+		return
+	}
+	defer release()
+
+	var changed *db.Device
+	if !all && len(ids) == 1 {
+		latest, err := s.queries.GetDevice(ctx, ids[0])
+		if errors.Is(err, sql.ErrNoRows) {
+			// Removed in the meantime; sendDevices sends the removal.
+			latest = db.Device{ID: ids[0]}
+		} else if err != nil {
+			slog.Error("failed to load device for broadcast", "id", ids[0], "err", err)
+			return
+		}
+		changed = &latest
+	} else {
+		prevChannels = nil
+	}
+	views, err := s.ListViews(ctx)
+	if err != nil {
+		slog.Error("failed to list devices", "err", err)
+		return
+	}
+	// Marshalling and fan-out do not touch the database.
+	release()
+	s.sendDevices(views, changed, prevChannels)
+}
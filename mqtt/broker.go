@@ -1,7 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"hash/fnv"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/hooks/auth"
@@ -22,20 +29,193 @@ func (h *authHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) boo
 	return ok
 }
 
+var errClientIdle = errors.New("client idle timeout")
+
+// idleHook tracks when each client last published and reports clients that
+// stay connected without publishing for longer than timeout, optionally
+// disconnecting them.
+type idleHook struct {
+	mqtt.HookBase
+	timeout    time.Duration
+	disconnect bool
+
+	mu      sync.Mutex
+	clients map[string]*idleClient
+	done    chan struct{}
+}
+
+type idleClient struct {
+	cl           *mqtt.Client
+	lastActivity time.Time
+	reported     bool
+}
+
+func (h *idleHook) ID() string {
+	return "idle-timeout"
+}
+
+func (h *idleHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mqtt.OnConnect, mqtt.OnDisconnect, mqtt.OnPublished}, []byte{b})
+}
+
+func (h *idleHook) Init(_ any) error {
+	h.clients = make(map[string]*idleClient)
+	h.done = make(chan struct{})
+
+	interval := max(h.timeout/2, time.Second)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.done:
+				return
+			case now := <-ticker.C:
+				h.check(now)
+			}
+		}
+	}()
+	return nil
+}
+
+func (h *idleHook) Stop() error {
+	close(h.done)
+	return nil
+}
+
+func (h *idleHook) OnConnect(cl *mqtt.Client, _ packets.Packet) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[cl.ID] = &idleClient{cl: cl, lastActivity: time.Now()}
+	return nil
+}
+
+func (h *idleHook) OnDisconnect(cl *mqtt.Client, _ error, _ bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// A session takeover connects the new client before the old one is
+	// disconnected, so only forget the entry if it is still ours.
+	if c, ok := h.clients[cl.ID]; ok && c.cl == cl {
+		delete(h.clients, cl.ID)
+	}
+}
+
+func (h *idleHook) OnPublished(cl *mqtt.Client, _ packets.Packet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.clients[cl.ID]; ok {
+		c.lastActivity = time.Now()
+		c.reported = false
+	}
+}
+
+func (h *idleHook) check(now time.Time) {
+	h.mu.Lock()
+	var idle []*idleClient
+	for _, c := range h.clients {
+		if !c.reported && now.Sub(c.lastActivity) > h.timeout {
+			c.reported = true
+			idle = append(idle, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range idle {
+		idleFor := now.Sub(c.lastActivity).Round(time.Second)
+		if h.disconnect {
+			slog.Info("disconnecting idle MQTT client", "client", c.cl.ID, "remote", c.cl.Net.Remote, "idle", idleFor)
+			c.cl.Stop(errClientIdle)
+		} else {
+			slog.Info("idle MQTT client", "client", c.cl.ID, "remote", c.cl.Net.Remote, "idle", idleFor)
+		}
+	}
+}
+
+// BrokerOptions configures optional broker behaviour.
+type BrokerOptions struct {
+	// IdleTimeout reports clients that publish nothing for this long.
+	// Zero disables idle detection.
+	IdleTimeout time.Duration
+	// IdleDisconnect disconnects idle clients instead of only logging them.
+	IdleDisconnect bool
+	// Workers is the number of goroutines handling published messages.
+	// Zero handles messages synchronously in the broker's delivery path.
+	Workers int
+	// QueueSize is the number of messages each worker buffers before new
+	// messages are dropped.
+	QueueSize int
+	// SampleHighWater is the worker queue depth above which position
+	// packets are sampled. Zero disables sampling.
+	SampleHighWater int
+	// SampleMaxDrop is the fraction of position packets dropped when a
+	// worker queue is full.
+	SampleMaxDrop float64
+	// ReadTopics are extra topic filters the device user may subscribe to,
+	// such as topics published by the server itself.
+	ReadTopics []string
+	// TLSConfig serves MQTT over TLS instead of plain TCP when set. It
+	// also applies to the WebSocket listener.
+	TLSConfig *tls.Config
+	// WebSocketAddr additionally serves MQTT over WebSocket on this
+	// address, with the same credentials and ACL. Empty disables it.
+	WebSocketAddr string
+}
+
+// inboundMessage is a published message waiting for a worker.
+type inboundMessage struct {
+	topic   string
+	payload []byte
+}
+
 // Broker wraps the mochi-mqtt server.
 type Broker struct {
 	server   *mqtt.Server
 	addr     string
 	username string
 	password string
+	opts     BrokerOptions
 	logger   *slog.Logger
+
+	queueMu  sync.RWMutex
+	queues   []chan inboundMessage
+	samplers []*queueSampler
+	closed   bool
+	workers  sync.WaitGroup
+	dropped  atomic.Uint64
+	sampled  atomic.Uint64
+	info     BrokerInfo
+}
+
+// BrokerInfo describes the running embedded broker, for operators debugging
+// client connections.
+type BrokerInfo struct {
+	Version string `json:"version"`
+	// ProtocolVersions are the MQTT versions clients may connect with.
+	ProtocolVersions []string             `json:"protocol_versions"`
+	Listeners        []BrokerListenerInfo `json:"listeners"`
+	Capabilities     mqtt.Capabilities    `json:"capabilities"`
+}
+
+// BrokerListenerInfo describes one broker listener.
+type BrokerListenerInfo struct {
+	ID       string `json:"id"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	TLS      bool   `json:"tls"`
 }
 
-func NewBroker(addr, username, password string, logger *slog.Logger) *Broker {
+// mqttProtocolVersions names the MQTT protocol levels mochi-mqtt accepts.
+var mqttProtocolVersions = []struct {
+	level byte
+	name  string
+}{{3, "3.1"}, {4, "3.1.1"}, {5, "5.0"}}
+
+func NewBroker(addr, username, password string, opts BrokerOptions, logger *slog.Logger) *Broker {
 	return &Broker{
 		addr:     addr,
 		username: username,
 		password: password,
+		opts:     opts,
 		logger:   logger,
 	}
 }
@@ -48,32 +228,56 @@ func (b *Broker) Start(onPublish func(topic string, payload []byte)) error {
 	})
 
 	// Auth hook — accept only connections with the configured credentials.
+	filters := auth.Filters{"msh/#": auth.ReadWrite}
+	for _, t := range b.opts.ReadTopics {
+		filters[auth.RString(t)] = auth.ReadOnly
+	}
 	if err := b.server.AddHook(new(authHook), &auth.Options{
 		Ledger: &auth.Ledger{
 			Auth: auth.AuthRules{
 				{Username: auth.RString(b.username), Password: auth.RString(b.password), Allow: true},
 			},
 			ACL: auth.ACLRules{
-				{Username: auth.RString(b.username), Filters: auth.Filters{"msh/#": auth.ReadWrite}},
+				{Username: auth.RString(b.username), Filters: filters},
 			},
 		},
 	}); err != nil {
 		return err
 	}
 
-	// TCP listener on the configured address.
-	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: b.addr})
+	// Idle hook — report (and optionally drop) clients that never publish.
+	if b.opts.IdleTimeout > 0 {
+		if err := b.server.AddHook(&idleHook{timeout: b.opts.IdleTimeout, disconnect: b.opts.IdleDisconnect}, nil); err != nil {
+			return err
+		}
+	}
+
+	// TCP listener on the configured address, with TLS if configured.
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: b.addr, TLSConfig: b.opts.TLSConfig})
 	if err := b.server.AddListener(tcp); err != nil {
 		return err
 	}
+	if b.opts.WebSocketAddr != "" {
+		ws := listeners.NewWebsocket(listeners.Config{ID: "ws", Address: b.opts.WebSocketAddr, TLSConfig: b.opts.TLSConfig})
+		if err := b.server.AddListener(ws); err != nil {
+			return err
+		}
+	}
 
-	// Subscribe inline to all Meshtastic JSON topics.
+	// Subscribe inline to all Meshtastic JSON topics. The subscription QoS
+	// only affects delivery to this handler: the server acknowledges a
+	// gateway's QoS 1 (PUBACK) or QoS 2 (PUBREC) publish itself before
+	// delivering it, whether or not anything is subscribed, so gateways
+	// never retransmit on our account.
+	deliver := b.startWorkers(onPublish)
 	if err := b.server.Subscribe("msh/+/2/json/#", 1, func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
-		onPublish(pk.TopicName, pk.Payload)
+		deliver(pk.TopicName, pk.Payload)
 	}); err != nil {
 		return err
 	}
 
+	b.info = b.readInfo()
+
 	go func() {
 		if err := b.server.Serve(); err != nil {
 			slog.Error("MQTT broker error", "err", err)
@@ -84,10 +288,131 @@ func (b *Broker) Start(onPublish func(topic string, payload []byte)) error {
 	return nil
 }
 
-// Stop gracefully shuts down the broker.
+// startWorkers starts the message workers and returns the function the
+// inline subscription delivers to. Messages are sharded by topic so the
+// messages of each topic are handled in order, sampled once a worker's queue passes
+// the high-water mark, and dropped rather than blocking the broker when it is
+// full.
+func (b *Broker) startWorkers(onPublish func(topic string, payload []byte)) func(topic string, payload []byte) {
+	if b.opts.Workers <= 0 {
+		return onPublish
+	}
+
+	b.queues = make([]chan inboundMessage, b.opts.Workers)
+	b.samplers = make([]*queueSampler, b.opts.Workers)
+	for i := range b.queues {
+		queue := make(chan inboundMessage, max(b.opts.QueueSize, 1))
+		b.queues[i] = queue
+		sampler := &queueSampler{
+			worker:    i,
+			highWater: b.opts.SampleHighWater,
+			capacity:  cap(queue),
+			maxDrop:   b.opts.SampleMaxDrop,
+			sampled:   &b.sampled,
+		}
+		b.samplers[i] = sampler
+		b.workers.Add(1)
+		go func() {
+			defer b.workers.Done()
+			for msg := range queue {
+				sampler.drained(len(queue))
+				onPublish(msg.topic, msg.payload)
+			}
+		}()
+	}
+
+	return func(topic string, payload []byte) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(topic))
+
+		b.queueMu.RLock()
+		defer b.queueMu.RUnlock()
+		if b.closed {
+			return
+		}
+		shard := h.Sum32() % uint32(len(b.queues))
+		queue := b.queues[shard]
+		if b.samplers[shard].drop(len(queue), payload) {
+			return
+		}
+
+		select {
+		case queue <- inboundMessage{topic: topic, payload: bytes.Clone(payload)}:
+		default:
+			// Log the first drop and then every 100th to avoid flooding
+			// the log during a burst.
+			if n := b.dropped.Add(1); n == 1 || n%100 == 0 {
+				slog.Warn("MQTT message queue full, dropping message", "topic", topic, "dropped", n)
+			}
+		}
+	}
+}
+
+// Publish sends a message from the server to subscribers of the embedded
+// broker.
+func (b *Broker) Publish(topic string, payload []byte, retain bool) error {
+	if b.server == nil {
+		return errors.New("broker not started")
+	}
+	return b.server.Publish(topic, payload, retain, 0)
+}
+
+// Dropped returns the number of messages dropped because a worker queue was
+// full.
+func (b *Broker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Sampled returns the number of position packets dropped by load sampling.
+func (b *Broker) Sampled() uint64 {
+	return b.sampled.Load()
+}
+
+// Info returns what the broker reported about itself when it started.
+func (b *Broker) Info() BrokerInfo {
+	return b.info
+}
+
+// readInfo collects the broker's version, capabilities and listeners. None
+// of them change once the broker is started, so it runs once.
+func (b *Broker) readInfo() BrokerInfo {
+	caps := *b.server.Options.Capabilities
+	info := BrokerInfo{
+		Version:          mqtt.Version,
+		ProtocolVersions: []string{},
+		Capabilities:     caps,
+	}
+	for _, v := range mqttProtocolVersions {
+		if v.level >= caps.MinimumProtocolVersion {
+			info.ProtocolVersions = append(info.ProtocolVersions, v.name)
+		}
+	}
+	for _, id := range []string{"tcp", "ws"} {
+		if l, ok := b.server.Listeners.Get(id); ok {
+			info.Listeners = append(info.Listeners, BrokerListenerInfo{
+				ID:       l.ID(),
+				Protocol: l.Protocol(),
+				Address:  l.Address(),
+				TLS:      b.opts.TLSConfig != nil,
+			})
+		}
+	}
+	return info
+}
+
+// Stop gracefully shuts down the broker and waits for queued messages to be
+// handled.
 func (b *Broker) Stop() error {
-	if b.server != nil {
-		return b.server.Close()
+	if b.server == nil {
+		return nil
 	}
-	return nil
+	err := b.server.Close()
+	b.queueMu.Lock()
+	b.closed = true
+	for _, queue := range b.queues {
+		close(queue)
+	}
+	b.queueMu.Unlock()
+	b.workers.Wait()
+	return err
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// freeAddr returns a local TCP address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func TestBrokerAcknowledgesQoS1(t *testing.T) {
+	addr := freeAddr(t)
+	b := NewBroker(addr, "devices", "secret", BrokerOptions{}, slog.Default())
+	received := make(chan string, 1)
+	if err := b.Start(func(topic string, _ []byte) { received <- topic }); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Stop() })
+
+	client := pahomqtt.NewClient(pahomqtt.NewClientOptions().
+		AddBroker("tcp://" + addr).
+		SetClientID("gateway").
+		SetUsername("devices").
+		SetPassword("secret").
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Millisecond))
+	if tok := client.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("connect: %v", tok.Error())
+	}
+	defer client.Disconnect(0)
+
+	const topic = "msh/US/2/json/LongFast/!00001000"
+	tok := client.Publish(topic, 1, false, []byte("{}"))
+	if !tok.WaitTimeout(5 * time.Second) {
+		t.Fatal("QoS 1 publish was not acknowledged")
+	}
+	if err := tok.Error(); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != topic {
+			t.Errorf("delivered topic %q, want %q", got, topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish was not delivered to the subscriber")
+	}
+}
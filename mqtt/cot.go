@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+)
+
+// cotTimeFormat is the timestamp layout used in CoT event attributes.
+const cotTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// cotEvent is a Cursor-on-Target event for a single device.
+type cotEvent struct {
+	XMLName xml.Name `xml:"event"`
+	Version string   `xml:"version,attr"`
+	UID     string   `xml:"uid,attr"`
+	Type    string   `xml:"type,attr"`
+	How     string   `xml:"how,attr"`
+	Time    string   `xml:"time,attr"`
+	Start   string   `xml:"start,attr"`
+	Stale   string   `xml:"stale,attr"`
+	Point   struct {
+		Lat float64 `xml:"lat,attr"`
+		Lon float64 `xml:"lon,attr"`
+		Hae float64 `xml:"hae,attr"`
+		Ce  string  `xml:"ce,attr"`
+		Le  string  `xml:"le,attr"`
+	} `xml:"point"`
+	Detail struct {
+		Contact struct {
+			Callsign string `xml:"callsign,attr"`
+		} `xml:"contact"`
+		Track struct {
+			Speed float64 `xml:"speed,attr"`
+		} `xml:"track"`
+		Status struct {
+			Battery int64 `xml:"battery,attr"`
+		} `xml:"status"`
+	} `xml:"detail"`
+}
+
+// newCoTEvent maps a device view to a friendly ground unit event that goes
+// stale after stale has passed since the device was last seen.
+func newCoTEvent(v DeviceView, stale time.Duration) cotEvent {
+	var ev cotEvent
+	ev.Version = "2.0"
+	ev.UID = "meshtastic-" + v.ID
+	ev.Type = "a-f-G-U-C"
+	ev.How = "m-g"
+	ev.Time = v.LastSeen.UTC().Format(cotTimeFormat)
+	ev.Start = ev.Time
+	ev.Stale = v.LastSeen.Add(stale).UTC().Format(cotTimeFormat)
+	ev.Point.Lat = v.Lat
+	ev.Point.Lon = v.Lon
+	ev.Point.Hae = v.Alt
+	// Circular and linear error are unknown.
+	ev.Point.Ce = "9999999.0"
+	ev.Point.Le = "9999999.0"
+	ev.Detail.Contact.Callsign = v.ID
+	ev.Detail.Track.Speed = v.Speed
+	ev.Detail.Status.Battery = v.BatteryLevel
+	return ev
+}
+
+// CoTSender streams CoT events for device positions to a TAK server.
+type CoTSender struct {
+	network string
+	addr    string
+	stale   time.Duration
+	events  chan DeviceView
+}
+
+// NewCoTSender returns a sender for a target of the form tcp://host:port or
+// udp://host:port.
+func NewCoTSender(target string, stale time.Duration) (*CoTSender, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "tcp" && u.Scheme != "udp" {
+		return nil, fmt.Errorf("unsupported CoT scheme %q: want tcp or udp", u.Scheme)
+	}
+	return &CoTSender{
+		network: u.Scheme,
+		addr:    u.Host,
+		stale:   stale,
+		events:  make(chan DeviceView, 256),
+	}, nil
+}
+
+// Send queues a device position for delivery. Devices without a fix are
+// skipped, and events are dropped if the queue is full.
+func (c *CoTSender) Send(v DeviceView) {
+	if !hasFix(v) {
+		return
+	}
+	select {
+	case c.events <- v:
+	default:
+		slog.Warn("CoT queue full, dropping event", "id", v.ID)
+	}
+}
+
+// Run delivers queued events until ctx is cancelled, reconnecting to the TAK
+// server after write errors.
+func (c *CoTSender) Run(ctx context.Context) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-c.events:
+			if conn == nil {
+				var err error
+				dialer := net.Dialer{Timeout: 5 * time.Second}
+				conn, err = dialer.DialContext(ctx, c.network, c.addr)
+				if err != nil {
+					slog.Warn("failed to connect to TAK server", "addr", c.addr, "err", err)
+					continue
+				}
+			}
+
+			data, err := xml.Marshal(newCoTEvent(v, c.stale))
+			if err != nil {
+				slog.Error("failed to marshal CoT event", "id", v.ID, "err", err)
+				continue
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write(append([]byte(xml.Header), data...)); err != nil {
+				slog.Warn("failed to send CoT event", "addr", c.addr, "err", err)
+				_ = conn.Close()
+				conn = nil
+			}
+		}
+	}
+}
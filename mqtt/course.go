@@ -0,0 +1,27 @@
+package main
+
+import "github.com/jarv/mqtt/db"
+
+// Course sources, telling clients where a device's course came from.
+const (
+	CourseReported = "reported"
+	CourseComputed = "computed"
+)
+
+// courseFor returns the course of a device moving from its previous fix to
+// lat/lon and where it came from. A reported ground track wins; otherwise the
+// bearing from the previous fix is used once the device moved at least
+// minMove meters. A stationary device keeps its previous course. prev is nil
+// for a device without a stored fix.
+func courseFor(p PositionPayload, prev *db.Device, lat, lon, minMove float64) (float64, string) {
+	if p.GroundTrack != 0 {
+		return float64(p.GroundTrack) / 1e5, CourseReported
+	}
+	if prev == nil || (prev.Lat == 0 && prev.Lon == 0) {
+		return 0, ""
+	}
+	if minMove > 0 && haversineMeters(prev.Lat, prev.Lon, lat, lon) >= minMove {
+		return bearingDegrees(prev.Lat, prev.Lon, lat, lon), CourseComputed
+	}
+	return prev.Course, prev.CourseSource
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jarv/mqtt/db"
+)
+
+func TestCourseFor(t *testing.T) {
+	prev := &db.Device{Lat: 0, Lon: 10, Course: 45, CourseSource: CourseReported}
+	tests := []struct {
+		name       string
+		p          PositionPayload
+		prev       *db.Device
+		lat, lon   float64
+		minMove    float64
+		wantCourse float64
+		wantSource string
+	}{
+		{"reported wins", PositionPayload{GroundTrack: 27000000}, prev, 1, 10, 50, 270, CourseReported},
+		{"no previous fix", PositionPayload{}, nil, 1, 10, 50, 0, ""},
+		{"previous fix unknown", PositionPayload{}, &db.Device{Course: 45}, 1, 10, 50, 0, ""},
+		{"computed north", PositionPayload{}, prev, 0.01, 10, 50, 0, CourseComputed},
+		{"computed east", PositionPayload{}, prev, 0, 10.01, 50, 90, CourseComputed},
+		{"computed south west", PositionPayload{}, prev, -0.01, 9.99, 50, 225, CourseComputed},
+		{"below min move keeps previous", PositionPayload{}, prev, 0.0001, 10, 50, 45, CourseReported},
+		{"zero min move keeps previous", PositionPayload{}, prev, 0.01, 10, 0, 45, CourseReported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			course, source := courseFor(tt.p, tt.prev, tt.lat, tt.lon, tt.minMove)
+			if math.Abs(course-tt.wantCourse) > 0.1 || source != tt.wantSource {
+				t.Errorf("courseFor() = %v, %q, want %v, %q", course, source, tt.wantCourse, tt.wantSource)
+			}
+		})
+	}
+}
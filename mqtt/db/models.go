@@ -5,22 +5,108 @@
 package db
 
 import (
+	"database/sql"
 	"time"
 )
 
 type Device struct {
-	ID        string    `db:"id" json:"id"`
-	Lat       float64   `db:"lat" json:"lat"`
-	Lon       float64   `db:"lon" json:"lon"`
-	Alt       float64   `db:"alt" json:"alt"`
-	Speed     float64   `db:"speed" json:"speed"`
-	Course    float64   `db:"course" json:"course"`
-	Sats      int64     `db:"sats" json:"sats"`
-	Hdop      float64   `db:"hdop" json:"hdop"`
-	BatteryMv int64     `db:"battery_mv" json:"battery_mv"`
-	Rssi      float64   `db:"rssi" json:"rssi"`
-	Snr       float64   `db:"snr" json:"snr"`
-	Online    int64     `db:"online" json:"online"`
-	LastSeen  time.Time `db:"last_seen" json:"last_seen"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	ID               string    `db:"id" json:"id"`
+	Lat              float64   `db:"lat" json:"lat"`
+	Lon              float64   `db:"lon" json:"lon"`
+	Alt              float64   `db:"alt" json:"alt"`
+	Speed            float64   `db:"speed" json:"speed"`
+	Course           float64   `db:"course" json:"course"`
+	Sats             int64     `db:"sats" json:"sats"`
+	Hdop             float64   `db:"hdop" json:"hdop"`
+	BatteryMv        int64     `db:"battery_mv" json:"battery_mv"`
+	Rssi             float64   `db:"rssi" json:"rssi"`
+	Snr              float64   `db:"snr" json:"snr"`
+	Online           int64     `db:"online" json:"online"`
+	LastSeen         time.Time `db:"last_seen" json:"last_seen"`
+	CreatedAt        time.Time `db:"created_at" json:"created_at"`
+	Channel          string    `db:"channel" json:"channel"`
+	RtcUnset         int64     `db:"rtc_unset" json:"rtc_unset"`
+	PositionAt       time.Time `db:"position_at" json:"position_at"`
+	PositionOverride int64     `db:"position_override" json:"position_override"`
+	LongName         string    `db:"long_name" json:"long_name"`
+	ShortName        string    `db:"short_name" json:"short_name"`
+	PositionSource   string    `db:"position_source" json:"position_source"`
+	CourseSource     string    `db:"course_source" json:"course_source"`
+	BatteryVoltage   float64   `db:"battery_voltage" json:"battery_voltage"`
+	Role             string    `db:"role" json:"role"`
+	EnvSensor        int64     `db:"env_sensor" json:"env_sensor"`
+}
+
+type DeviceEnrichment struct {
+	DeviceID   string    `db:"device_id" json:"device_id"`
+	Owner      string    `db:"owner" json:"owner"`
+	Team       string    `db:"team" json:"team"`
+	Notes      string    `db:"notes" json:"notes"`
+	EnrichedAt time.Time `db:"enriched_at" json:"enriched_at"`
+}
+
+type DeviceTag struct {
+	DeviceID string `db:"device_id" json:"device_id"`
+	Tag      string `db:"tag" json:"tag"`
+}
+
+type GatewaySample struct {
+	DeviceID string    `db:"device_id" json:"device_id"`
+	Gateway  string    `db:"gateway" json:"gateway"`
+	Rssi     float64   `db:"rssi" json:"rssi"`
+	Snr      float64   `db:"snr" json:"snr"`
+	HopsAway int64     `db:"hops_away" json:"hops_away"`
+	HeardAt  time.Time `db:"heard_at" json:"heard_at"`
+}
+
+type Message struct {
+	ID         int64     `db:"id" json:"id"`
+	NodeID     string    `db:"node_id" json:"node_id"`
+	Channel    string    `db:"channel" json:"channel"`
+	Text       string    `db:"text" json:"text"`
+	ReceivedAt time.Time `db:"received_at" json:"received_at"`
+}
+
+type Position struct {
+	ID         int64     `db:"id" json:"id"`
+	DeviceID   string    `db:"device_id" json:"device_id"`
+	Lat        float64   `db:"lat" json:"lat"`
+	Lon        float64   `db:"lon" json:"lon"`
+	Alt        float64   `db:"alt" json:"alt"`
+	Speed      float64   `db:"speed" json:"speed"`
+	RecordedAt time.Time `db:"recorded_at" json:"recorded_at"`
+}
+
+type RawPacket struct {
+	ID         int64     `db:"id" json:"id"`
+	Topic      string    `db:"topic" json:"topic"`
+	Payload    []byte    `db:"payload" json:"payload"`
+	ReceivedAt time.Time `db:"received_at" json:"received_at"`
+}
+
+type RouteHop struct {
+	DeviceID  string          `db:"device_id" json:"device_id"`
+	Direction string          `db:"direction" json:"direction"`
+	Hop       int64           `db:"hop" json:"hop"`
+	NodeID    string          `db:"node_id" json:"node_id"`
+	Snr       sql.NullFloat64 `db:"snr" json:"snr"`
+	HeardAt   time.Time       `db:"heard_at" json:"heard_at"`
+}
+
+type RoutingResult struct {
+	DeviceID  string    `db:"device_id" json:"device_id"`
+	Delivered int64     `db:"delivered" json:"delivered"`
+	HeardAt   time.Time `db:"heard_at" json:"heard_at"`
+}
+
+type TelemetryHistory struct {
+	ID                 int64           `db:"id" json:"id"`
+	DeviceID           string          `db:"device_id" json:"device_id"`
+	Kind               string          `db:"kind" json:"kind"`
+	BatteryLevel       float64         `db:"battery_level" json:"battery_level"`
+	Voltage            float64         `db:"voltage" json:"voltage"`
+	Temperature        sql.NullFloat64 `db:"temperature" json:"temperature"`
+	RelativeHumidity   sql.NullFloat64 `db:"relative_humidity" json:"relative_humidity"`
+	BarometricPressure sql.NullFloat64 `db:"barometric_pressure" json:"barometric_pressure"`
+	RecordedAt         time.Time       `db:"recorded_at" json:"recorded_at"`
 }
@@ -7,19 +7,232 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"time"
 )
 
-const deleteStaleDevices = `-- name: DeleteStaleDevices :exec
-DELETE FROM devices WHERE last_seen < datetime('now', '-48 hours')
+const addDeviceTag = `-- name: AddDeviceTag :exec
+INSERT OR IGNORE INTO device_tags (device_id, tag) VALUES (?, ?)
 `
 
-func (q *Queries) DeleteStaleDevices(ctx context.Context) error {
-	_, err := q.db.ExecContext(ctx, deleteStaleDevices)
+type AddDeviceTagParams struct {
+	DeviceID string `db:"device_id" json:"device_id"`
+	Tag      string `db:"tag" json:"tag"`
+}
+
+func (q *Queries) AddDeviceTag(ctx context.Context, arg AddDeviceTagParams) error {
+	_, err := q.db.ExecContext(ctx, addDeviceTag, arg.DeviceID, arg.Tag)
+	return err
+}
+
+const addRouteHop = `-- name: AddRouteHop :exec
+INSERT INTO route_hops (device_id, direction, hop, node_id, snr) VALUES (?, ?, ?, ?, ?)
+`
+
+type AddRouteHopParams struct {
+	DeviceID  string          `db:"device_id" json:"device_id"`
+	Direction string          `db:"direction" json:"direction"`
+	Hop       int64           `db:"hop" json:"hop"`
+	NodeID    string          `db:"node_id" json:"node_id"`
+	Snr       sql.NullFloat64 `db:"snr" json:"snr"`
+}
+
+func (q *Queries) AddRouteHop(ctx context.Context, arg AddRouteHopParams) error {
+	_, err := q.db.ExecContext(ctx, addRouteHop,
+		arg.DeviceID,
+		arg.Direction,
+		arg.Hop,
+		arg.NodeID,
+		arg.Snr,
+	)
+	return err
+}
+
+const addRoutingResult = `-- name: AddRoutingResult :exec
+INSERT INTO routing_results (device_id, delivered) VALUES (?, ?)
+`
+
+type AddRoutingResultParams struct {
+	DeviceID  string `db:"device_id" json:"device_id"`
+	Delivered int64  `db:"delivered" json:"delivered"`
+}
+
+func (q *Queries) AddRoutingResult(ctx context.Context, arg AddRoutingResultParams) error {
+	_, err := q.db.ExecContext(ctx, addRoutingResult, arg.DeviceID, arg.Delivered)
+	return err
+}
+
+const clearDevicePositionOverride = `-- name: ClearDevicePositionOverride :one
+UPDATE devices SET position_override = 0 WHERE id = ?
+RETURNING id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at, channel, rtc_unset, position_at, position_override, long_name, short_name, position_source, course_source, battery_voltage, role, env_sensor
+`
+
+func (q *Queries) ClearDevicePositionOverride(ctx context.Context, id string) (Device, error) {
+	row := q.db.QueryRowContext(ctx, clearDevicePositionOverride, id)
+	var i Device
+	err := row.Scan(
+		&i.ID,
+		&i.Lat,
+		&i.Lon,
+		&i.Alt,
+		&i.Speed,
+		&i.Course,
+		&i.Sats,
+		&i.Hdop,
+		&i.BatteryMv,
+		&i.Rssi,
+		&i.Snr,
+		&i.Online,
+		&i.LastSeen,
+		&i.CreatedAt,
+		&i.Channel,
+		&i.RtcUnset,
+		&i.PositionAt,
+		&i.PositionOverride,
+		&i.LongName,
+		&i.ShortName,
+		&i.PositionSource,
+		&i.CourseSource,
+		&i.BatteryVoltage,
+		&i.Role,
+		&i.EnvSensor,
+	)
+	return i, err
+}
+
+const countDevices = `-- name: CountDevices :one
+SELECT COUNT(*) FROM devices
+`
+
+func (q *Queries) CountDevices(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countDevices)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteDeviceTags = `-- name: DeleteDeviceTags :exec
+DELETE FROM device_tags WHERE device_id = ?
+`
+
+func (q *Queries) DeleteDeviceTags(ctx context.Context, deviceID string) error {
+	_, err := q.db.ExecContext(ctx, deleteDeviceTags, deviceID)
+	return err
+}
+
+const deleteMessagesOverCount = `-- name: DeleteMessagesOverCount :exec
+DELETE FROM messages WHERE id <= (
+    SELECT id FROM messages ORDER BY id DESC LIMIT 1 OFFSET ?1
+)
+`
+
+// Deletes the oldest messages so only the newest max_count are kept.
+func (q *Queries) DeleteMessagesOverCount(ctx context.Context, maxCount int64) error {
+	_, err := q.db.ExecContext(ctx, deleteMessagesOverCount, maxCount)
+	return err
+}
+
+const deletePositionsBefore = `-- name: DeletePositionsBefore :exec
+DELETE FROM positions WHERE recorded_at < datetime(?1)
+`
+
+func (q *Queries) DeletePositionsBefore(ctx context.Context, cutoff interface{}) error {
+	_, err := q.db.ExecContext(ctx, deletePositionsBefore, cutoff)
+	return err
+}
+
+const deleteRawPacketsOverSize = `-- name: DeleteRawPacketsOverSize :exec
+DELETE FROM raw_packets WHERE id <= (
+    SELECT id FROM (
+        SELECT id, SUM(length(payload)) OVER (ORDER BY id DESC) AS total
+        FROM raw_packets
+    )
+    WHERE total > ?1
+    ORDER BY id DESC
+    LIMIT 1
+)
+`
+
+// Deletes the oldest packets so the newest ones fit in max_bytes of payload.
+func (q *Queries) DeleteRawPacketsOverSize(ctx context.Context, maxBytes interface{}) error {
+	_, err := q.db.ExecContext(ctx, deleteRawPacketsOverSize, maxBytes)
+	return err
+}
+
+const deleteRouteHops = `-- name: DeleteRouteHops :exec
+DELETE FROM route_hops WHERE device_id = ?
+`
+
+func (q *Queries) DeleteRouteHops(ctx context.Context, deviceID string) error {
+	_, err := q.db.ExecContext(ctx, deleteRouteHops, deviceID)
+	return err
+}
+
+const deleteStaleDevices = `-- name: DeleteStaleDevices :many
+DELETE FROM devices WHERE last_seen < datetime(?1) RETURNING id
+`
+
+func (q *Queries) DeleteStaleDevices(ctx context.Context, cutoff interface{}) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, deleteStaleDevices, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteStaleGatewaySamples = `-- name: DeleteStaleGatewaySamples :exec
+DELETE FROM gateway_samples WHERE heard_at < datetime('now', '-48 hours')
+`
+
+func (q *Queries) DeleteStaleGatewaySamples(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleGatewaySamples)
+	return err
+}
+
+const deleteStaleRouteHops = `-- name: DeleteStaleRouteHops :exec
+DELETE FROM route_hops WHERE heard_at < datetime('now', '-48 hours')
+`
+
+func (q *Queries) DeleteStaleRouteHops(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleRouteHops)
+	return err
+}
+
+const deleteStaleRoutingResults = `-- name: DeleteStaleRoutingResults :exec
+DELETE FROM routing_results WHERE heard_at < datetime('now', '-24 hours')
+`
+
+func (q *Queries) DeleteStaleRoutingResults(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleRoutingResults)
+	return err
+}
+
+const deleteTelemetryBefore = `-- name: DeleteTelemetryBefore :exec
+DELETE FROM telemetry_history WHERE recorded_at < datetime(?1)
+`
+
+func (q *Queries) DeleteTelemetryBefore(ctx context.Context, cutoff interface{}) error {
+	_, err := q.db.ExecContext(ctx, deleteTelemetryBefore, cutoff)
 	return err
 }
 
 const getDevice = `-- name: GetDevice :one
-SELECT id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at FROM devices WHERE id = ? LIMIT 1
+SELECT id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at, channel, rtc_unset, position_at, position_override, long_name, short_name, position_source, course_source, battery_voltage, role, env_sensor FROM devices WHERE id = ? LIMIT 1
 `
 
 func (q *Queries) GetDevice(ctx context.Context, id string) (Device, error) {
@@ -40,12 +253,183 @@ func (q *Queries) GetDevice(ctx context.Context, id string) (Device, error) {
 		&i.Online,
 		&i.LastSeen,
 		&i.CreatedAt,
+		&i.Channel,
+		&i.RtcUnset,
+		&i.PositionAt,
+		&i.PositionOverride,
+		&i.LongName,
+		&i.ShortName,
+		&i.PositionSource,
+		&i.CourseSource,
+		&i.BatteryVoltage,
+		&i.Role,
+		&i.EnvSensor,
+	)
+	return i, err
+}
+
+const getDeviceEnrichment = `-- name: GetDeviceEnrichment :one
+SELECT device_id, owner, team, notes, enriched_at FROM device_enrichment WHERE device_id = ? LIMIT 1
+`
+
+func (q *Queries) GetDeviceEnrichment(ctx context.Context, deviceID string) (DeviceEnrichment, error) {
+	row := q.db.QueryRowContext(ctx, getDeviceEnrichment, deviceID)
+	var i DeviceEnrichment
+	err := row.Scan(
+		&i.DeviceID,
+		&i.Owner,
+		&i.Team,
+		&i.Notes,
+		&i.EnrichedAt,
+	)
+	return i, err
+}
+
+const getLatestTelemetry = `-- name: GetLatestTelemetry :one
+SELECT id, device_id, kind, battery_level, voltage, temperature, relative_humidity, barometric_pressure, recorded_at FROM telemetry_history
+WHERE device_id = ? AND kind = ?
+ORDER BY recorded_at DESC, id DESC
+LIMIT 1
+`
+
+type GetLatestTelemetryParams struct {
+	DeviceID string `db:"device_id" json:"device_id"`
+	Kind     string `db:"kind" json:"kind"`
+}
+
+func (q *Queries) GetLatestTelemetry(ctx context.Context, arg GetLatestTelemetryParams) (TelemetryHistory, error) {
+	row := q.db.QueryRowContext(ctx, getLatestTelemetry, arg.DeviceID, arg.Kind)
+	var i TelemetryHistory
+	err := row.Scan(
+		&i.ID,
+		&i.DeviceID,
+		&i.Kind,
+		&i.BatteryLevel,
+		&i.Voltage,
+		&i.Temperature,
+		&i.RelativeHumidity,
+		&i.BarometricPressure,
+		&i.RecordedAt,
 	)
 	return i, err
 }
 
+const insertMessage = `-- name: InsertMessage :exec
+INSERT INTO messages (node_id, channel, text) VALUES (?, ?, ?)
+`
+
+type InsertMessageParams struct {
+	NodeID  string `db:"node_id" json:"node_id"`
+	Channel string `db:"channel" json:"channel"`
+	Text    string `db:"text" json:"text"`
+}
+
+func (q *Queries) InsertMessage(ctx context.Context, arg InsertMessageParams) error {
+	_, err := q.db.ExecContext(ctx, insertMessage, arg.NodeID, arg.Channel, arg.Text)
+	return err
+}
+
+const insertPosition = `-- name: InsertPosition :exec
+INSERT INTO positions (device_id, lat, lon, alt, speed)
+VALUES (?, ?, ?, ?, ?)
+`
+
+type InsertPositionParams struct {
+	DeviceID string  `db:"device_id" json:"device_id"`
+	Lat      float64 `db:"lat" json:"lat"`
+	Lon      float64 `db:"lon" json:"lon"`
+	Alt      float64 `db:"alt" json:"alt"`
+	Speed    float64 `db:"speed" json:"speed"`
+}
+
+func (q *Queries) InsertPosition(ctx context.Context, arg InsertPositionParams) error {
+	_, err := q.db.ExecContext(ctx, insertPosition,
+		arg.DeviceID,
+		arg.Lat,
+		arg.Lon,
+		arg.Alt,
+		arg.Speed,
+	)
+	return err
+}
+
+const insertRawPacket = `-- name: InsertRawPacket :exec
+INSERT INTO raw_packets (topic, payload) VALUES (?, ?)
+`
+
+type InsertRawPacketParams struct {
+	Topic   string `db:"topic" json:"topic"`
+	Payload []byte `db:"payload" json:"payload"`
+}
+
+func (q *Queries) InsertRawPacket(ctx context.Context, arg InsertRawPacketParams) error {
+	_, err := q.db.ExecContext(ctx, insertRawPacket, arg.Topic, arg.Payload)
+	return err
+}
+
+const insertTelemetry = `-- name: InsertTelemetry :exec
+INSERT INTO telemetry_history (device_id, kind, battery_level, voltage, temperature, relative_humidity, barometric_pressure)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+type InsertTelemetryParams struct {
+	DeviceID           string          `db:"device_id" json:"device_id"`
+	Kind               string          `db:"kind" json:"kind"`
+	BatteryLevel       float64         `db:"battery_level" json:"battery_level"`
+	Voltage            float64         `db:"voltage" json:"voltage"`
+	Temperature        sql.NullFloat64 `db:"temperature" json:"temperature"`
+	RelativeHumidity   sql.NullFloat64 `db:"relative_humidity" json:"relative_humidity"`
+	BarometricPressure sql.NullFloat64 `db:"barometric_pressure" json:"barometric_pressure"`
+}
+
+func (q *Queries) InsertTelemetry(ctx context.Context, arg InsertTelemetryParams) error {
+	_, err := q.db.ExecContext(ctx, insertTelemetry,
+		arg.DeviceID,
+		arg.Kind,
+		arg.BatteryLevel,
+		arg.Voltage,
+		arg.Temperature,
+		arg.RelativeHumidity,
+		arg.BarometricPressure,
+	)
+	return err
+}
+
+const listDeviceEnrichment = `-- name: ListDeviceEnrichment :many
+SELECT device_id, owner, team, notes, enriched_at FROM device_enrichment ORDER BY device_id
+`
+
+func (q *Queries) ListDeviceEnrichment(ctx context.Context) ([]DeviceEnrichment, error) {
+	rows, err := q.db.QueryContext(ctx, listDeviceEnrichment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeviceEnrichment
+	for rows.Next() {
+		var i DeviceEnrichment
+		if err := rows.Scan(
+			&i.DeviceID,
+			&i.Owner,
+			&i.Team,
+			&i.Notes,
+			&i.EnrichedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listDevices = `-- name: ListDevices :many
-SELECT id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at FROM devices ORDER BY last_seen DESC
+SELECT id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at, channel, rtc_unset, position_at, position_override, long_name, short_name, position_source, course_source, battery_voltage, role, env_sensor FROM devices ORDER BY last_seen DESC
 `
 
 func (q *Queries) ListDevices(ctx context.Context) ([]Device, error) {
@@ -72,6 +456,361 @@ func (q *Queries) ListDevices(ctx context.Context) ([]Device, error) {
 			&i.Online,
 			&i.LastSeen,
 			&i.CreatedAt,
+			&i.Channel,
+			&i.RtcUnset,
+			&i.PositionAt,
+			&i.PositionOverride,
+			&i.LongName,
+			&i.ShortName,
+			&i.PositionSource,
+			&i.CourseSource,
+			&i.BatteryVoltage,
+			&i.Role,
+			&i.EnvSensor,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDevicesWithShortName = `-- name: ListDevicesWithShortName :many
+SELECT id FROM devices WHERE short_name = ? AND id != ?
+`
+
+type ListDevicesWithShortNameParams struct {
+	ShortName string `db:"short_name" json:"short_name"`
+	ID        string `db:"id" json:"id"`
+}
+
+func (q *Queries) ListDevicesWithShortName(ctx context.Context, arg ListDevicesWithShortNameParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listDevicesWithShortName, arg.ShortName, arg.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDeviceTags = `-- name: ListDeviceTags :many
+SELECT device_id, tag FROM device_tags ORDER BY device_id, tag
+`
+
+func (q *Queries) ListDeviceTags(ctx context.Context) ([]DeviceTag, error) {
+	rows, err := q.db.QueryContext(ctx, listDeviceTags)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeviceTag
+	for rows.Next() {
+		var i DeviceTag
+		if err := rows.Scan(&i.DeviceID, &i.Tag); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGatewaySamples = `-- name: ListGatewaySamples :many
+SELECT device_id, gateway, rssi, snr, hops_away, heard_at FROM gateway_samples ORDER BY device_id, snr DESC
+`
+
+func (q *Queries) ListGatewaySamples(ctx context.Context) ([]GatewaySample, error) {
+	rows, err := q.db.QueryContext(ctx, listGatewaySamples)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GatewaySample
+	for rows.Next() {
+		var i GatewaySample
+		if err := rows.Scan(
+			&i.DeviceID,
+			&i.Gateway,
+			&i.Rssi,
+			&i.Snr,
+			&i.HopsAway,
+			&i.HeardAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGatewaySamplesForDevice = `-- name: ListGatewaySamplesForDevice :many
+SELECT device_id, gateway, rssi, snr, hops_away, heard_at FROM gateway_samples WHERE device_id = ? ORDER BY snr DESC
+`
+
+func (q *Queries) ListGatewaySamplesForDevice(ctx context.Context, deviceID string) ([]GatewaySample, error) {
+	rows, err := q.db.QueryContext(ctx, listGatewaySamplesForDevice, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GatewaySample
+	for rows.Next() {
+		var i GatewaySample
+		if err := rows.Scan(
+			&i.DeviceID,
+			&i.Gateway,
+			&i.Rssi,
+			&i.Snr,
+			&i.HopsAway,
+			&i.HeardAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPositionsSince = `-- name: ListPositionsSince :many
+SELECT id, device_id, lat, lon, alt, speed, recorded_at FROM positions
+WHERE device_id = ?1 AND recorded_at >= datetime(?2)
+ORDER BY recorded_at, id
+`
+
+type ListPositionsSinceParams struct {
+	DeviceID string      `db:"device_id" json:"device_id"`
+	Since    interface{} `db:"since" json:"since"`
+}
+
+func (q *Queries) ListPositionsSince(ctx context.Context, arg ListPositionsSinceParams) ([]Position, error) {
+	rows, err := q.db.QueryContext(ctx, listPositionsSince, arg.DeviceID, arg.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Position
+	for rows.Next() {
+		var i Position
+		if err := rows.Scan(
+			&i.ID,
+			&i.DeviceID,
+			&i.Lat,
+			&i.Lon,
+			&i.Alt,
+			&i.Speed,
+			&i.RecordedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRecentMessages = `-- name: ListRecentMessages :many
+SELECT id, node_id, channel, text, received_at FROM messages ORDER BY id DESC LIMIT ?
+`
+
+func (q *Queries) ListRecentMessages(ctx context.Context, limit int64) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, listRecentMessages, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.NodeID,
+			&i.Channel,
+			&i.Text,
+			&i.ReceivedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRouteHops = `-- name: ListRouteHops :many
+SELECT device_id, direction, hop, node_id, snr, heard_at FROM route_hops WHERE device_id = ? ORDER BY direction DESC, hop
+`
+
+func (q *Queries) ListRouteHops(ctx context.Context, deviceID string) ([]RouteHop, error) {
+	rows, err := q.db.QueryContext(ctx, listRouteHops, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RouteHop
+	for rows.Next() {
+		var i RouteHop
+		if err := rows.Scan(
+			&i.DeviceID,
+			&i.Direction,
+			&i.Hop,
+			&i.NodeID,
+			&i.Snr,
+			&i.HeardAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoutingStats = `-- name: ListRoutingStats :many
+SELECT device_id, CAST(SUM(delivered) AS INTEGER) AS delivered, COUNT(*) AS total
+FROM routing_results
+WHERE heard_at >= datetime('now', '-24 hours')
+GROUP BY device_id
+`
+
+type ListRoutingStatsRow struct {
+	DeviceID  string `db:"device_id" json:"device_id"`
+	Delivered int64  `db:"delivered" json:"delivered"`
+	Total     int64  `db:"total" json:"total"`
+}
+
+// Counts the routing results of each device heard in the last 24 hours.
+func (q *Queries) ListRoutingStats(ctx context.Context) ([]ListRoutingStatsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listRoutingStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRoutingStatsRow
+	for rows.Next() {
+		var i ListRoutingStatsRow
+		if err := rows.Scan(&i.DeviceID, &i.Delivered, &i.Total); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTagsForDevice = `-- name: ListTagsForDevice :many
+SELECT tag FROM device_tags WHERE device_id = ? ORDER BY tag
+`
+
+func (q *Queries) ListTagsForDevice(ctx context.Context, deviceID string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listTagsForDevice, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		items = append(items, tag)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTelemetrySince = `-- name: ListTelemetrySince :many
+SELECT id, device_id, kind, battery_level, voltage, temperature, relative_humidity, barometric_pressure, recorded_at FROM telemetry_history
+WHERE device_id = ?1 AND recorded_at >= datetime(?2)
+ORDER BY recorded_at, id
+`
+
+type ListTelemetrySinceParams struct {
+	DeviceID string      `db:"device_id" json:"device_id"`
+	Since    interface{} `db:"since" json:"since"`
+}
+
+func (q *Queries) ListTelemetrySince(ctx context.Context, arg ListTelemetrySinceParams) ([]TelemetryHistory, error) {
+	rows, err := q.db.QueryContext(ctx, listTelemetrySince, arg.DeviceID, arg.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TelemetryHistory
+	for rows.Next() {
+		var i TelemetryHistory
+		if err := rows.Scan(
+			&i.ID,
+			&i.DeviceID,
+			&i.Kind,
+			&i.BatteryLevel,
+			&i.Voltage,
+			&i.Temperature,
+			&i.RelativeHumidity,
+			&i.BarometricPressure,
+			&i.RecordedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -86,6 +825,19 @@ func (q *Queries) ListDevices(ctx context.Context) ([]Device, error) {
 	return items, nil
 }
 
+const markDeviceEnvSensor = `-- name: MarkDeviceEnvSensor :execrows
+UPDATE devices SET env_sensor = 1 WHERE id = ? AND env_sensor = 0
+`
+
+// Records that a device reports environment telemetry.
+func (q *Queries) MarkDeviceEnvSensor(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markDeviceEnvSensor, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const markDeviceOffline = `-- name: MarkDeviceOffline :exec
 UPDATE devices SET online = 0 WHERE id = ?
 `
@@ -95,9 +847,146 @@ func (q *Queries) MarkDeviceOffline(ctx context.Context, id string) error {
 	return err
 }
 
+const ping = `-- name: Ping :one
+SELECT 1
+`
+
+// Checks that the database answers queries.
+func (q *Queries) Ping(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, ping)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const reconcileDevicesOnline = `-- name: ReconcileDevicesOnline :execrows
+UPDATE devices SET online = (last_seen >= datetime(?1))
+WHERE online != (last_seen >= datetime(?1))
+`
+
+// Sets online from last_seen: devices seen since cutoff are online, the rest offline.
+func (q *Queries) ReconcileDevicesOnline(ctx context.Context, cutoff interface{}) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reconcileDevicesOnline, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setDeviceNames = `-- name: SetDeviceNames :one
+INSERT INTO devices (id, long_name, short_name, channel, role, last_seen)
+VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(id) DO UPDATE SET
+    long_name  = excluded.long_name,
+    short_name = excluded.short_name,
+    channel    = excluded.channel,
+    role       = COALESCE(NULLIF(excluded.role, ''), devices.role),
+    last_seen  = CURRENT_TIMESTAMP
+RETURNING id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at, channel, rtc_unset, position_at, position_override, long_name, short_name, position_source, course_source, battery_voltage, role, env_sensor
+`
+
+type SetDeviceNamesParams struct {
+	ID        string `db:"id" json:"id"`
+	LongName  string `db:"long_name" json:"long_name"`
+	ShortName string `db:"short_name" json:"short_name"`
+	Channel   string `db:"channel" json:"channel"`
+	Role      string `db:"role" json:"role"`
+}
+
+func (q *Queries) SetDeviceNames(ctx context.Context, arg SetDeviceNamesParams) (Device, error) {
+	row := q.db.QueryRowContext(ctx, setDeviceNames,
+		arg.ID,
+		arg.LongName,
+		arg.ShortName,
+		arg.Channel,
+		arg.Role,
+	)
+	var i Device
+	err := row.Scan(
+		&i.ID,
+		&i.Lat,
+		&i.Lon,
+		&i.Alt,
+		&i.Speed,
+		&i.Course,
+		&i.Sats,
+		&i.Hdop,
+		&i.BatteryMv,
+		&i.Rssi,
+		&i.Snr,
+		&i.Online,
+		&i.LastSeen,
+		&i.CreatedAt,
+		&i.Channel,
+		&i.RtcUnset,
+		&i.PositionAt,
+		&i.PositionOverride,
+		&i.LongName,
+		&i.ShortName,
+		&i.PositionSource,
+		&i.CourseSource,
+		&i.BatteryVoltage,
+		&i.Role,
+		&i.EnvSensor,
+	)
+	return i, err
+}
+
+const setDevicePosition = `-- name: SetDevicePosition :one
+UPDATE devices
+SET lat = ?, lon = ?, alt = ?, position_override = 1
+WHERE id = ?
+RETURNING id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at, channel, rtc_unset, position_at, position_override, long_name, short_name, position_source, course_source, battery_voltage, role, env_sensor
+`
+
+type SetDevicePositionParams struct {
+	Lat float64 `db:"lat" json:"lat"`
+	Lon float64 `db:"lon" json:"lon"`
+	Alt float64 `db:"alt" json:"alt"`
+	ID  string  `db:"id" json:"id"`
+}
+
+func (q *Queries) SetDevicePosition(ctx context.Context, arg SetDevicePositionParams) (Device, error) {
+	row := q.db.QueryRowContext(ctx, setDevicePosition,
+		arg.Lat,
+		arg.Lon,
+		arg.Alt,
+		arg.ID,
+	)
+	var i Device
+	err := row.Scan(
+		&i.ID,
+		&i.Lat,
+		&i.Lon,
+		&i.Alt,
+		&i.Speed,
+		&i.Course,
+		&i.Sats,
+		&i.Hdop,
+		&i.BatteryMv,
+		&i.Rssi,
+		&i.Snr,
+		&i.Online,
+		&i.LastSeen,
+		&i.CreatedAt,
+		&i.Channel,
+		&i.RtcUnset,
+		&i.PositionAt,
+		&i.PositionOverride,
+		&i.LongName,
+		&i.ShortName,
+		&i.PositionSource,
+		&i.CourseSource,
+		&i.BatteryVoltage,
+		&i.Role,
+		&i.EnvSensor,
+	)
+	return i, err
+}
+
 const upsertDevice = `-- name: UpsertDevice :one
-INSERT INTO devices (id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+INSERT INTO devices (id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, channel, rtc_unset, position_at, position_source, course_source, battery_voltage, last_seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 ON CONFLICT(id) DO UPDATE SET
     lat        = excluded.lat,
     lon        = excluded.lon,
@@ -110,23 +999,35 @@ ON CONFLICT(id) DO UPDATE SET
     rssi       = excluded.rssi,
     snr        = excluded.snr,
     online     = excluded.online,
+    channel    = excluded.channel,
+    rtc_unset  = excluded.rtc_unset,
+    position_at = excluded.position_at,
+    position_source = excluded.position_source,
+    course_source = excluded.course_source,
+    battery_voltage = excluded.battery_voltage,
     last_seen  = CURRENT_TIMESTAMP
-RETURNING id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at
+RETURNING id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen, created_at, channel, rtc_unset, position_at, position_override, long_name, short_name, position_source, course_source, battery_voltage, role, env_sensor
 `
 
 type UpsertDeviceParams struct {
-	ID        string  `db:"id" json:"id"`
-	Lat       float64 `db:"lat" json:"lat"`
-	Lon       float64 `db:"lon" json:"lon"`
-	Alt       float64 `db:"alt" json:"alt"`
-	Speed     float64 `db:"speed" json:"speed"`
-	Course    float64 `db:"course" json:"course"`
-	Sats      int64   `db:"sats" json:"sats"`
-	Hdop      float64 `db:"hdop" json:"hdop"`
-	BatteryMv int64   `db:"battery_mv" json:"battery_mv"`
-	Rssi      float64 `db:"rssi" json:"rssi"`
-	Snr       float64 `db:"snr" json:"snr"`
-	Online    int64   `db:"online" json:"online"`
+	ID             string    `db:"id" json:"id"`
+	Lat            float64   `db:"lat" json:"lat"`
+	Lon            float64   `db:"lon" json:"lon"`
+	Alt            float64   `db:"alt" json:"alt"`
+	Speed          float64   `db:"speed" json:"speed"`
+	Course         float64   `db:"course" json:"course"`
+	Sats           int64     `db:"sats" json:"sats"`
+	Hdop           float64   `db:"hdop" json:"hdop"`
+	BatteryMv      int64     `db:"battery_mv" json:"battery_mv"`
+	Rssi           float64   `db:"rssi" json:"rssi"`
+	Snr            float64   `db:"snr" json:"snr"`
+	Online         int64     `db:"online" json:"online"`
+	Channel        string    `db:"channel" json:"channel"`
+	RtcUnset       int64     `db:"rtc_unset" json:"rtc_unset"`
+	PositionAt     time.Time `db:"position_at" json:"position_at"`
+	PositionSource string    `db:"position_source" json:"position_source"`
+	CourseSource   string    `db:"course_source" json:"course_source"`
+	BatteryVoltage float64   `db:"battery_voltage" json:"battery_voltage"`
 }
 
 func (q *Queries) UpsertDevice(ctx context.Context, arg UpsertDeviceParams) (Device, error) {
@@ -143,6 +1044,12 @@ func (q *Queries) UpsertDevice(ctx context.Context, arg UpsertDeviceParams) (Dev
 		arg.Rssi,
 		arg.Snr,
 		arg.Online,
+		arg.Channel,
+		arg.RtcUnset,
+		arg.PositionAt,
+		arg.PositionSource,
+		arg.CourseSource,
+		arg.BatteryVoltage,
 	)
 	var i Device
 	err := row.Scan(
@@ -160,6 +1067,73 @@ func (q *Queries) UpsertDevice(ctx context.Context, arg UpsertDeviceParams) (Dev
 		&i.Online,
 		&i.LastSeen,
 		&i.CreatedAt,
+		&i.Channel,
+		&i.RtcUnset,
+		&i.PositionAt,
+		&i.PositionOverride,
+		&i.LongName,
+		&i.ShortName,
+		&i.PositionSource,
+		&i.CourseSource,
+		&i.BatteryVoltage,
+		&i.Role,
+		&i.EnvSensor,
 	)
 	return i, err
 }
+
+const upsertDeviceEnrichment = `-- name: UpsertDeviceEnrichment :exec
+INSERT INTO device_enrichment (device_id, owner, team, notes, enriched_at)
+VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(device_id) DO UPDATE SET
+    owner       = excluded.owner,
+    team        = excluded.team,
+    notes       = excluded.notes,
+    enriched_at = CURRENT_TIMESTAMP
+`
+
+type UpsertDeviceEnrichmentParams struct {
+	DeviceID string `db:"device_id" json:"device_id"`
+	Owner    string `db:"owner" json:"owner"`
+	Team     string `db:"team" json:"team"`
+	Notes    string `db:"notes" json:"notes"`
+}
+
+func (q *Queries) UpsertDeviceEnrichment(ctx context.Context, arg UpsertDeviceEnrichmentParams) error {
+	_, err := q.db.ExecContext(ctx, upsertDeviceEnrichment,
+		arg.DeviceID,
+		arg.Owner,
+		arg.Team,
+		arg.Notes,
+	)
+	return err
+}
+
+const upsertGatewaySample = `-- name: UpsertGatewaySample :exec
+INSERT INTO gateway_samples (device_id, gateway, rssi, snr, hops_away, heard_at)
+VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(device_id, gateway) DO UPDATE SET
+    rssi      = excluded.rssi,
+    snr       = excluded.snr,
+    hops_away = excluded.hops_away,
+    heard_at  = CURRENT_TIMESTAMP
+`
+
+type UpsertGatewaySampleParams struct {
+	DeviceID string  `db:"device_id" json:"device_id"`
+	Gateway  string  `db:"gateway" json:"gateway"`
+	Rssi     float64 `db:"rssi" json:"rssi"`
+	Snr      float64 `db:"snr" json:"snr"`
+	HopsAway int64   `db:"hops_away" json:"hops_away"`
+}
+
+func (q *Queries) UpsertGatewaySample(ctx context.Context, arg UpsertGatewaySampleParams) error {
+	_, err := q.db.ExecContext(ctx, upsertGatewaySample,
+		arg.DeviceID,
+		arg.Gateway,
+		arg.Rssi,
+		arg.Snr,
+		arg.HopsAway,
+	)
+	return err
+}
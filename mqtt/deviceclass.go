@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// DeviceClass is the kind of node a device is, for clients that style
+// trackers, sensors and infrastructure differently.
+type DeviceClass string
+
+const (
+	// ClassTracker is a node that moves and reports its position.
+	ClassTracker DeviceClass = "tracker"
+	// ClassSensor is a node reporting environment readings, usually from a
+	// fixed place and often without GPS.
+	ClassSensor DeviceClass = "sensor"
+	// ClassInfrastructure is a router or repeater relaying for the mesh.
+	ClassInfrastructure DeviceClass = "infrastructure"
+)
+
+// defaultDeviceClassRoles maps the Meshtastic roles whose class is implied
+// by the role alone.
+const defaultDeviceClassRoles = "ROUTER=infrastructure,ROUTER_LATE=infrastructure,ROUTER_CLIENT=infrastructure,REPEATER=infrastructure,SENSOR=sensor,TRACKER=tracker,TAK_TRACKER=tracker"
+
+// deviceRoles lists the Meshtastic Config.DeviceConfig.Role names, indexed
+// by their enum value.
+var deviceRoles = []string{
+	"CLIENT", "CLIENT_MUTE", "ROUTER", "ROUTER_CLIENT", "REPEATER", "TRACKER",
+	"SENSOR", "TAK", "CLIENT_HIDDEN", "LOST_AND_FOUND", "TAK_TRACKER", "ROUTER_LATE",
+}
+
+// nodeRole is the role reported in a nodeinfo packet. Firmware encodes it
+// as the enum value or, in some versions, its name; both are read as the
+// name. Unknown values are kept as their number.
+type nodeRole string
+
+func (r *nodeRole) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		if n >= 0 && n < len(deviceRoles) {
+			*r = nodeRole(deviceRoles[n])
+		} else {
+			*r = nodeRole(strconv.Itoa(n))
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid role %s", data)
+	}
+	*r = nodeRole(strings.ToUpper(s))
+	return nil
+}
+
+// parseDeviceClassRoles parses a comma-separated list of ROLE=class rules.
+func parseDeviceClassRoles(s string) (map[string]DeviceClass, error) {
+	rules := make(map[string]DeviceClass)
+	if strings.TrimSpace(s) == "" {
+		return rules, nil
+	}
+	for _, rule := range strings.Split(s, ",") {
+		role, class, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule %q: want ROLE=class", rule)
+		}
+		switch c := DeviceClass(strings.TrimSpace(class)); c {
+		case ClassTracker, ClassSensor, ClassInfrastructure:
+			rules[strings.ToUpper(strings.TrimSpace(role))] = c
+		default:
+			return nil, fmt.Errorf("unknown device class %q: want tracker, sensor or infrastructure", class)
+		}
+	}
+	return rules, nil
+}
+
+// classify returns the class of d: the class its role maps to, else sensor
+// for a node that reports environment telemetry but has never had a fix,
+// else tracker.
+func (s *Subscriber) classify(d db.Device) DeviceClass {
+	if c, ok := s.opts.DeviceClassRoles[d.Role]; ok {
+		return c
+	}
+	if d.EnvSensor != 0 && d.Lat == 0 && d.Lon == 0 {
+		return ClassSensor
+	}
+	return ClassTracker
+}
+
+// environment reports whether t carries environment sensor readings.
+func (t TelemetryPayload) environment() bool {
+	return t.Temperature != nil || t.RelativeHumidity != nil || t.BarometricPressure != nil
+}
+
+// markEnvSensor records that a device reports environment telemetry and,
+// the first time, broadcasts it since its class may change. Devices not
+// stored yet are marked on their next environment packet.
+func (s *Subscriber) markEnvSensor(ctx context.Context, id string) {
+	n, err := s.queries.MarkDeviceEnvSensor(ctx, id)
+	if err != nil {
+		slog.Error("failed to mark environment sensor", "id", id, "err", err)
+		return
+	}
+	if n == 0 || s.isPending(id) {
+		return
+	}
+	device, err := s.queries.GetDevice(ctx, id)
+	if err != nil {
+		slog.Error("failed to load device", "id", id, "err", err)
+		return
+	}
+	slog.Debug("device reports environment telemetry", "id", id)
+	s.broadcastDevice(device, device.Channel)
+}
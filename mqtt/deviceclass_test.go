@@ -0,0 +1,38 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseDeviceClassRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]DeviceClass
+		wantErr bool
+	}{
+		{"empty", "", map[string]DeviceClass{}, false},
+		{"blank", "  ", map[string]DeviceClass{}, false},
+		{"single", "ROUTER=infrastructure", map[string]DeviceClass{"ROUTER": ClassInfrastructure}, false},
+		{"several with spaces", " router = infrastructure , SENSOR=sensor,TRACKER=tracker", map[string]DeviceClass{
+			"ROUTER":  ClassInfrastructure,
+			"SENSOR":  ClassSensor,
+			"TRACKER": ClassTracker,
+		}, false},
+		{"missing class", "ROUTER", nil, true},
+		{"unknown class", "ROUTER=relay", nil, true},
+		{"trailing comma", "ROUTER=infrastructure,", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDeviceClassRoles(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDeviceClassRoles(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("parseDeviceClassRoles(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
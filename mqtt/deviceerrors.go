@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errDeviceErrorsDisabled is returned by DeviceStatus when per-device error
+// tracking is off.
+var errDeviceErrorsDisabled = errors.New("device error tracking disabled")
+
+// DeviceStatus reports why packets from a device were dropped, if any were.
+type DeviceStatus struct {
+	ID          string     `json:"id"`
+	ErrorCount  uint64     `json:"error_count"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// maxDeviceErrors bounds the devices tracked, since node IDs come from
+// publishers and can be spoofed. The device with the oldest failure makes
+// room for a new one.
+const maxDeviceErrors = 10000
+
+// deviceErrorTracker keeps the number of parse and validation failures of
+// each device and only the most recent one, in memory.
+type deviceErrorTracker struct {
+	mu      sync.Mutex
+	devices map[string]*deviceErrors
+}
+
+type deviceErrors struct {
+	count   uint64
+	lastErr string
+	lastAt  time.Time
+}
+
+func newDeviceErrorTracker() *deviceErrorTracker {
+	return &deviceErrorTracker{devices: make(map[string]*deviceErrors)}
+}
+
+// record counts a failure of kind ("position payload", ...) for id.
+func (t *deviceErrorTracker) record(id, kind string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	de, ok := t.devices[id]
+	if !ok {
+		if len(t.devices) >= maxDeviceErrors {
+			t.evictOldest()
+		}
+		de = &deviceErrors{}
+		t.devices[id] = de
+	}
+	de.count++
+	de.lastErr = fmt.Sprintf("%s: %v", kind, err)
+	de.lastAt = time.Now()
+}
+
+// evictOldest forgets the device whose last failure is the oldest. t.mu
+// must be held.
+func (t *deviceErrorTracker) evictOldest() {
+	var oldest string
+	var oldestAt time.Time
+	for id, de := range t.devices {
+		if oldest == "" || de.lastAt.Before(oldestAt) {
+			oldest, oldestAt = id, de.lastAt
+		}
+	}
+	delete(t.devices, oldest)
+}
+
+// status returns the failures recorded for id, and whether there were any.
+func (t *deviceErrorTracker) status(id string) (DeviceStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	de, ok := t.devices[id]
+	if !ok {
+		return DeviceStatus{ID: id}, false
+	}
+	at := de.lastAt
+	return DeviceStatus{ID: id, ErrorCount: de.count, LastError: de.lastErr, LastErrorAt: &at}, true
+}
+
+// prune forgets devices whose last failure was before cutoff.
+func (t *deviceErrorTracker) prune(cutoff time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, de := range t.devices {
+		if de.lastAt.Before(cutoff) {
+			delete(t.devices, id)
+		}
+	}
+}
+
+// payloadError records a payload of the device in info that failed to parse,
+// both for its topic and for the device.
+func (s *Subscriber) payloadError(info packetInfo, kind string, err error) {
+	s.parseErrors.Record(info.topic, kind, err)
+	s.deviceError(info.id, kind, err)
+}
+
+// deviceError records a packet from id that was dropped as invalid.
+func (s *Subscriber) deviceError(id, kind string, err error) {
+	if s.deviceErrors != nil {
+		s.deviceErrors.record(id, kind, err)
+	}
+}
+
+// DeviceStatus returns the failures recorded for id. Devices that are
+// neither stored nor have failures return sql.ErrNoRows.
+func (s *Subscriber) DeviceStatus(ctx context.Context, id string) (DeviceStatus, error) {
+	if s.deviceErrors == nil {
+		return DeviceStatus{}, errDeviceErrorsDisabled
+	}
+	status, ok := s.deviceErrors.status(id)
+	if ok {
+		return status, nil
+	}
+	if _, err := s.queries.GetDevice(ctx, id); err != nil {
+		return DeviceStatus{}, err
+	}
+	return status, nil
+}
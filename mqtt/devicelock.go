@@ -0,0 +1,45 @@
+package main
+
+import "sync"
+
+// deviceLocks serialises the read-modify-write updates of each device row.
+// Packets from different gateways are delivered concurrently, so without it
+// a position and a telemetry update of the same device could both read the
+// old row and one would write back stale fields of the other.
+type deviceLocks struct {
+	mu    sync.Mutex
+	locks map[string]*deviceLock
+}
+
+type deviceLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newDeviceLocks() *deviceLocks {
+	return &deviceLocks{locks: make(map[string]*deviceLock)}
+}
+
+// lock waits until no other update of id is running and returns the function
+// that releases it. Locks of idle devices are dropped.
+func (l *deviceLocks) lock(id string) func() {
+	l.mu.Lock()
+	dl, ok := l.locks[id]
+	if !ok {
+		dl = &deviceLock{}
+		l.locks[id] = dl
+	}
+	dl.refs++
+	l.mu.Unlock()
+
+	dl.mu.Lock()
+	return func() {
+		dl.mu.Unlock()
+		l.mu.Lock()
+		dl.refs--
+		if dl.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeviceLocks(t *testing.T) {
+	l := newDeviceLocks()
+	var wg sync.WaitGroup
+	// Each device has its own counter, only guarded by its lock.
+	counts := map[string]*int{"!00000001": new(int), "!00000002": new(int)}
+	for range 50 {
+		for id, count := range counts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				unlock := l.lock(id)
+				defer unlock()
+				n := *count
+				time.Sleep(time.Microsecond)
+				*count = n + 1
+			}()
+		}
+	}
+	wg.Wait()
+
+	for id, n := range counts {
+		if *n != 50 {
+			t.Errorf("%s updated %d times, want 50", id, *n)
+		}
+	}
+	if len(l.locks) != 0 {
+		t.Errorf("%d locks left after every update finished", len(l.locks))
+	}
+}
+
+func TestConcurrentPositionAndTelemetry(t *testing.T) {
+	s := newTestSubscriber(t, nil, SubscriberOptions{})
+	const node = 0x1000
+	publishPacket(t, s, node, "position", PositionPayload{LatitudeI: 515000000, LongitudeI: -1000000})
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			publishPacket(t, s, node, "position", PositionPayload{LatitudeI: 515000000 + int64(i), LongitudeI: -1000000})
+		}()
+		go func() {
+			defer wg.Done()
+			publishPacket(t, s, node, "telemetry", TelemetryPayload{BatteryLevel: 77, Voltage: 3.9})
+		}()
+	}
+	wg.Wait()
+
+	device, err := s.queries.GetDevice(context.Background(), nodeID(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.BatteryMv != 77 || device.BatteryVoltage != 3.9 {
+		t.Errorf("battery = %d%%, %vV after concurrent updates, want 77%%, 3.9V", device.BatteryMv, device.BatteryVoltage)
+	}
+	if device.Lat < 51.5 || device.Lat > 51.5001 {
+		t.Errorf("lat = %v after concurrent updates, want one of the reported fixes", device.Lat)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// DeviceRecord is everything stored about a device: every column of its row,
+// under the column names, plus the data kept alongside it. It is meant for
+// debugging and power users; DeviceView is the curated form clients use.
+type DeviceRecord struct {
+	db.Device
+	Tags       []string        `json:"tags"`
+	Enrichment *Enrichment     `json:"enrichment"`
+	Gateways   []GatewaySample `json:"gateways"`
+	// Reliability is as in DeviceView.
+	Reliability *float64 `json:"reliability"`
+	// Pending is set while a new device is held back from the device
+	// list by -hold-new-devices.
+	Pending bool `json:"pending"`
+}
+
+// DeviceRecord returns the full record of a device, or sql.ErrNoRows if it
+// is not stored.
+func (s *Subscriber) DeviceRecord(ctx context.Context, id string) (DeviceRecord, error) {
+	device, err := s.queries.GetDevice(ctx, id)
+	if err != nil {
+		return DeviceRecord{}, err
+	}
+	r := DeviceRecord{Device: device, Pending: s.isPending(id)}
+	r.Lat, r.Lon = s.publicPosition(r.Lat, r.Lon)
+
+	if r.Tags, err = s.queries.ListTagsForDevice(ctx, id); err != nil {
+		return DeviceRecord{}, err
+	}
+	if r.Tags == nil {
+		r.Tags = []string{}
+	}
+	e, err := s.queries.GetDeviceEnrichment(ctx, id)
+	switch {
+	case err == nil:
+		r.Enrichment = &Enrichment{Owner: e.Owner, Team: e.Team, Notes: e.Notes}
+	case !errors.Is(err, sql.ErrNoRows):
+		return DeviceRecord{}, err
+	}
+	if r.Gateways, err = s.GatewaySamples(ctx, id); err != nil {
+		return DeviceRecord{}, err
+	}
+	stats, err := s.queries.ListRoutingStats(ctx)
+	if err != nil {
+		return DeviceRecord{}, err
+	}
+	if rel, ok := deviceReliability(stats)[id]; ok {
+		r.Reliability = &rel
+	}
+	return r, nil
+}
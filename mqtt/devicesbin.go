@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Binary device list served at /api/devices.bin for clients too constrained
+// to parse the JSON list. All integers are little-endian.
+//
+// Header, 8 bytes:
+//
+//	offset size field
+//	0      4    magic "MTDL"
+//	4      1    version, currently 1
+//	5      1    record size in bytes, currently 40
+//	6      2    record count (uint16)
+//
+// Each record, in the order of the JSON list:
+//
+//	offset size field
+//	0      4    node number (uint32), 0 if the ID is not a node ID
+//	4      4    latitude in 1e-7 degrees (int32)
+//	8      4    longitude in 1e-7 degrees (int32)
+//	12     4    altitude in meters (int32)
+//	16     4    last seen, Unix seconds (uint32)
+//	20     2    course in 0.01 degrees (uint16)
+//	22     2    speed in 0.01 m/s (uint16)
+//	24     1    battery level in percent (uint8)
+//	25     1    flags: bit 0 online, bit 1 has a fix, bit 2 position
+//	            pinned by an operator, bit 3 device clock unset
+//	26     14   display name, UTF-8, truncated to whole characters and
+//	            padded with zero bytes
+//
+// Later versions only append fields to records, so clients should advance by
+// the record size from the header rather than a fixed 40.
+const (
+	devicesBinMagic      = "MTDL"
+	devicesBinVersion    = 1
+	devicesBinRecordSize = 40
+	devicesBinNameSize   = 14
+	// devicesBinMaxRecords is the most records the uint16 count can hold.
+	devicesBinMaxRecords = math.MaxUint16
+)
+
+// Record flags.
+const (
+	devicesBinOnline = 1 << iota
+	devicesBinHasFix
+	devicesBinOverride
+	devicesBinRTCUnset
+)
+
+type devicesBinHeader struct {
+	Magic      [4]byte
+	Version    uint8
+	RecordSize uint8
+	Count      uint16
+}
+
+type devicesBinRecord struct {
+	Node     uint32
+	Lat      int32
+	Lon      int32
+	Alt      int32
+	LastSeen uint32
+	Course   uint16
+	Speed    uint16
+	Battery  uint8
+	Flags    uint8
+	Name     [devicesBinNameSize]byte
+}
+
+// writeDevicesBinary writes views in the binary format above. Lists longer
+// than the count field allows are truncated.
+func writeDevicesBinary(w io.Writer, views []DeviceView) error {
+	if len(views) > devicesBinMaxRecords {
+		views = views[:devicesBinMaxRecords]
+	}
+	bw := bufio.NewWriter(w)
+	header := devicesBinHeader{
+		Version:    devicesBinVersion,
+		RecordSize: devicesBinRecordSize,
+		Count:      uint16(len(views)),
+	}
+	copy(header.Magic[:], devicesBinMagic)
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	for _, v := range views {
+		if err := binary.Write(bw, binary.LittleEndian, devicesBinRecordFor(v)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func devicesBinRecordFor(v DeviceView) devicesBinRecord {
+	r := devicesBinRecord{
+		Lat:      int32(math.Round(v.Lat * 1e7)),
+		Lon:      int32(math.Round(v.Lon * 1e7)),
+		Alt:      int32(math.Round(v.Alt)),
+		LastSeen: uint32(max(v.LastSeen.Unix(), 0)),
+		Speed:    uint16(min(max(math.Round(v.Speed*100), 0), math.MaxUint16)),
+		Battery:  uint8(min(max(v.BatteryLevel, 0), math.MaxUint8)),
+	}
+	// Negative and NaN courses would convert to arbitrary integers.
+	if c := math.Mod(math.Mod(v.Course, 360)+360, 360); !math.IsNaN(c) {
+		r.Course = uint16(math.Round(c*100)) % 36000
+	}
+	if n, err := strconv.ParseUint(strings.TrimPrefix(v.ID, "!"), 16, 32); err == nil {
+		r.Node = uint32(n)
+	}
+	if v.Online {
+		r.Flags |= devicesBinOnline
+	}
+	if hasFix(v) {
+		r.Flags |= devicesBinHasFix
+	}
+	if v.Override {
+		r.Flags |= devicesBinOverride
+	}
+	if v.RTCUnset {
+		r.Flags |= devicesBinRTCUnset
+	}
+
+	name := v.DisplayName
+	for len(name) > devicesBinNameSize {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	copy(r.Name[:], name)
+	return r
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// maxEnrichmentBytes bounds the webhook response read.
+const maxEnrichmentBytes = 64 << 10
+
+// enrichmentRequest is posted to the enrichment webhook.
+type enrichmentRequest struct {
+	ID string `json:"id"`
+}
+
+// Enrichment is the metadata an external asset database holds for a device.
+type Enrichment struct {
+	Owner string `json:"owner"`
+	Team  string `json:"team"`
+	Notes string `json:"notes"`
+}
+
+// Enricher looks up devices in an external service the first time they are
+// seen and, on every refresh, those seen since startup whose stored metadata
+// is missing or older than the refresh interval. Lookups run in the
+// background so ingestion never waits for the webhook.
+type Enricher struct {
+	url     string
+	refresh time.Duration
+	sub     *Subscriber
+	client  *http.Client
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	seen    map[string]bool
+	pending map[string]bool
+}
+
+// NewEnricher returns an enricher posting to url. A zero refresh looks up
+// each device once.
+func NewEnricher(url string, refresh time.Duration, sub *Subscriber) *Enricher {
+	return &Enricher{
+		url:     url,
+		refresh: refresh,
+		sub:     sub,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		wake:    make(chan struct{}, 1),
+		seen:    make(map[string]bool),
+		pending: make(map[string]bool),
+	}
+}
+
+// Update queues a lookup for devices not seen since startup. Devices with
+// fresh stored metadata are skipped when the lookup runs.
+func (e *Enricher) Update(v DeviceView) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.seen[v.ID] {
+		e.seen[v.ID] = true
+		e.pending[v.ID] = true
+		e.signal()
+	}
+}
+
+// Forget drops removed devices so they are neither refreshed nor kept in
+// memory. A removed device that returns is looked up again.
+func (e *Enricher) Forget(ids []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, id := range ids {
+		delete(e.seen, id)
+		delete(e.pending, id)
+	}
+}
+
+// signal wakes Run if it is waiting. e.mu must be held.
+func (e *Enricher) signal() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next removes and returns a pending device, or false if there is none.
+func (e *Enricher) next() (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id := range e.pending {
+		delete(e.pending, id)
+		return id, true
+	}
+	return "", false
+}
+
+// Run performs pending lookups and, with a refresh interval, periodically
+// queues every device seen since startup again, until ctx is cancelled.
+// Lookups that failed are retried then too.
+func (e *Enricher) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if e.refresh > 0 {
+		ticker := time.NewTicker(e.refresh)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.wake:
+			for id, ok := e.next(); ok && ctx.Err() == nil; id, ok = e.next() {
+				e.enrich(ctx, id)
+			}
+		case <-tick:
+			e.mu.Lock()
+			for id := range e.seen {
+				e.pending[id] = true
+			}
+			e.signal()
+			e.mu.Unlock()
+		}
+	}
+}
+
+// enrich looks up a device unless its stored metadata is still fresh.
+func (e *Enricher) enrich(ctx context.Context, id string) {
+	enrichedAt, err := e.sub.EnrichedAt(ctx, id)
+	if err != nil {
+		slog.Error("failed to load enrichment", "id", id, "err", err)
+		return
+	}
+	if !enrichedAt.IsZero() && (e.refresh <= 0 || time.Since(enrichedAt) < e.refresh) {
+		return
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	enrichment, err := e.lookup(reqCtx, id)
+	if err != nil {
+		slog.Warn("device enrichment failed", "id", id, "err", err)
+		return
+	}
+	if err := e.sub.SetEnrichment(reqCtx, id, enrichment); err != nil {
+		slog.Error("failed to store enrichment", "id", id, "err", err)
+	}
+}
+
+func (e *Enricher) lookup(ctx context.Context, id string) (Enrichment, error) {
+	body, err := json.Marshal(enrichmentRequest{ID: id})
+	if err != nil {
+		return Enrichment{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return Enrichment{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return Enrichment{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return Enrichment{}, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	var enrichment Enrichment
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichmentBytes)).Decode(&enrichment); err != nil {
+		return Enrichment{}, fmt.Errorf("invalid webhook response: %w", err)
+	}
+	return enrichment, nil
+}
+
+// EnrichedAt returns when a device was last enriched, or the zero time if
+// it never was.
+func (s *Subscriber) EnrichedAt(ctx context.Context, id string) (time.Time, error) {
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer release()
+
+	row, err := s.queries.GetDeviceEnrichment(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return row.EnrichedAt, err
+}
+
+// SetEnrichment stores the metadata of a device and broadcasts it. Like
+// tags, it is kept when a stale device is removed.
+func (s *Subscriber) SetEnrichment(ctx context.Context, id string, e Enrichment) error {
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = s.queries.UpsertDeviceEnrichment(ctx, db.UpsertDeviceEnrichmentParams{
+		DeviceID: id,
+		Owner:    e.Owner,
+		Team:     e.Team,
+		Notes:    e.Notes,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("device enriched", "id", id, "owner", e.Owner, "team", e.Team)
+
+	device, err := s.queries.GetDevice(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.broadcastDevices(&device)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// kmlDocument is the root of a KML 2.2 export.
+type kmlDocument struct {
+	XMLName  xml.Name `xml:"kml"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Document struct {
+		Name       string         `xml:"name"`
+		Placemarks []kmlPlacemark `xml:"Placemark"`
+	} `xml:"Document"`
+}
+
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	Point       struct {
+		Coordinates string `xml:"coordinates"`
+	} `xml:"Point"`
+}
+
+// writeKML writes a KML document with a Placemark per device. Devices without
+// a GPS fix are skipped.
+func writeKML(w io.Writer, views []DeviceView) error {
+	var doc kmlDocument
+	doc.Xmlns = "http://www.opengis.net/kml/2.2"
+	doc.Document.Name = "MQTT Device Tracker"
+
+	for _, v := range views {
+		if !hasFix(v) {
+			continue
+		}
+		var pm kmlPlacemark
+		pm.Name = v.ID
+		pm.Description = fmt.Sprintf("Battery: %d%%\nLast seen: %s", v.BatteryLevel, v.LastSeen.Format(time.RFC3339))
+		// KML coordinates are lon,lat[,alt].
+		pm.Point.Coordinates = fmt.Sprintf("%.7f,%.7f,%.1f", v.Lon, v.Lat, v.Alt)
+		doc.Document.Placemarks = append(doc.Document.Placemarks, pm)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Feed event kinds besides the alert kinds.
+const FeedNewDevice = "new_device"
+
+// FeedEvent is a significant device event published in the Atom feed.
+type FeedEvent struct {
+	DeviceID string
+	Kind     string
+	Title    string
+	Time     time.Time
+}
+
+// EventFeed keeps the most recent significant events: devices seen for the
+// first time and alert transitions.
+type EventFeed struct {
+	size int
+
+	mu     sync.Mutex
+	events []FeedEvent
+	known  map[string]bool
+}
+
+// NewEventFeed returns a feed that keeps the newest size events.
+func NewEventFeed(size int) *EventFeed {
+	return &EventFeed{size: size, known: make(map[string]bool)}
+}
+
+// Seed marks already stored devices as known so they are not reported as
+// new after a restart.
+func (f *EventFeed) Seed(views []DeviceView) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, v := range views {
+		f.known[v.ID] = true
+	}
+}
+
+// CheckDevice records a new-device event the first time a device is shown.
+func (f *EventFeed) CheckDevice(v DeviceView) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.known[v.ID] {
+		return
+	}
+	f.known[v.ID] = true
+	f.add(FeedEvent{
+		DeviceID: v.ID,
+		Kind:     FeedNewDevice,
+		Title:    "New device " + v.ID,
+		Time:     v.LastSeen,
+	})
+}
+
+// Forget drops removed devices from the known set, so it stays no larger
+// than the device table. A removed device that returns is reported as new.
+func (f *EventFeed) Forget(ids []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		delete(f.known, id)
+	}
+}
+
+// AddAlert records an alert transition.
+func (f *EventFeed) AddAlert(a Alert) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.add(FeedEvent{
+		DeviceID: a.DeviceID,
+		Kind:     a.Kind,
+		Title:    alertTitle(a),
+		Time:     a.Time,
+	})
+}
+
+// add appends e, dropping the oldest event once the feed is full. f.mu must
+// be held.
+func (f *EventFeed) add(e FeedEvent) {
+	f.events = append(f.events, e)
+	if len(f.events) > f.size {
+		f.events = slices.Delete(f.events, 0, len(f.events)-f.size)
+	}
+}
+
+// Events returns the kept events, newest first.
+func (f *EventFeed) Events() []FeedEvent {
+	f.mu.Lock()
+	events := slices.Clone(f.events)
+	f.mu.Unlock()
+	slices.Reverse(events)
+	return events
+}
+
+func alertTitle(a Alert) string {
+	switch {
+	case a.Kind == AlertBattery && a.Active:
+		return fmt.Sprintf("%s battery low (%.0f%%)", a.DeviceID, a.Value)
+	case a.Kind == AlertBattery:
+		return fmt.Sprintf("%s battery recovered (%.0f%%)", a.DeviceID, a.Value)
+	case a.Kind == AlertOffline && a.Active:
+		return a.DeviceID + " went offline"
+	case a.Kind == AlertOffline:
+		return a.DeviceID + " is back online"
+	case a.Kind == AlertTemperature && a.Active:
+		return fmt.Sprintf("%s temperature high (%.1f °C)", a.DeviceID, a.Value)
+	case a.Kind == AlertTemperature:
+		return fmt.Sprintf("%s temperature back to normal (%.1f °C)", a.DeviceID, a.Value)
+	default:
+		return a.DeviceID + " " + a.Kind
+	}
+}
+
+// atomFeed is the root of an Atom 1.0 document.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category atomCategory `xml:"category"`
+	Summary  string       `xml:"summary"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// writeAtom writes events, newest first, as an Atom feed served at selfURL.
+func writeAtom(w io.Writer, selfURL string, events []FeedEvent, now time.Time) error {
+	feed := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		ID:      selfURL,
+		Title:   "MQTT Device Tracker events",
+		Updated: now.UTC().Format(time.RFC3339),
+		Link:    atomLink{Rel: "self", Href: selfURL},
+		Author:  atomAuthor{Name: "MQTT Device Tracker"},
+	}
+	if len(events) > 0 {
+		feed.Updated = events[0].Time.UTC().Format(time.RFC3339)
+	}
+	for _, e := range events {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:       fmt.Sprintf("urn:mqtt-tracker:event:%s:%s:%d", e.DeviceID, e.Kind, e.Time.UnixNano()),
+			Title:    e.Title,
+			Updated:  e.Time.UTC().Format(time.RFC3339),
+			Category: atomCategory{Term: e.Kind},
+			Summary:  e.Title,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(feed)
+}
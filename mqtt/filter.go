@@ -0,0 +1,204 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// filterRoomPrefix prefixes rooms for clients that follow a subset of
+// devices. The rest of the name is the encoded filter, so clients with the
+// same filter share a room and a single marshalled snapshot.
+const filterRoomPrefix = "filter:"
+
+// deviceFilter selects the devices a client or API request is interested in.
+// Empty fields match every device.
+type deviceFilter struct {
+	Channel        string
+	Tag            string
+	BBox           bbox
+	ExcludeOffline bool
+}
+
+// bbox is a bounding box in degrees. The zero value matches everywhere.
+type bbox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// parseBBox parses "minLon,minLat,maxLon,maxLat". An empty string is the
+// zero box.
+func parseBBox(v string) (bbox, error) {
+	if v == "" {
+		return bbox{}, nil
+	}
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return bbox{}, fmt.Errorf("invalid bbox %q: want minLon,minLat,maxLon,maxLat", v)
+	}
+	var f [4]float64
+	for i, p := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return bbox{}, fmt.Errorf("invalid bbox %q: %w", v, err)
+		}
+		f[i] = n
+	}
+	return newBBox(f[:])
+}
+
+// newBBox builds a box from [minLon, minLat, maxLon, maxLat].
+func newBBox(f []float64) (bbox, error) {
+	if len(f) == 0 {
+		return bbox{}, nil
+	}
+	if len(f) != 4 {
+		return bbox{}, fmt.Errorf("bbox needs 4 values, got %d", len(f))
+	}
+	b := bbox{MinLon: f[0], MinLat: f[1], MaxLon: f[2], MaxLat: f[3]}
+	if b.MinLat > b.MaxLat || b.MinLon > b.MaxLon ||
+		b.MinLat < -90 || b.MaxLat > 90 || b.MinLon < -180 || b.MaxLon > 180 {
+		return bbox{}, fmt.Errorf("bbox out of range or inverted")
+	}
+	return b, nil
+}
+
+func (b bbox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+}
+
+func (b bbox) contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// filterFromQuery reads ?channel=, ?tag=, ?bbox= and ?include_offline= from
+// a request query. Without include_offline, offline devices are excluded if
+// excludeOffline is set.
+func filterFromQuery(q url.Values, excludeOffline bool) (deviceFilter, error) {
+	box, err := parseBBox(q.Get("bbox"))
+	if err != nil {
+		return deviceFilter{}, err
+	}
+	if v := q.Get("include_offline"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return deviceFilter{}, fmt.Errorf("invalid include_offline %q: want true or false", v)
+		}
+		excludeOffline = !include
+	}
+	return deviceFilter{
+		Channel:        q.Get("channel"),
+		Tag:            q.Get("tag"),
+		BBox:           box,
+		ExcludeOffline: excludeOffline,
+	}, nil
+}
+
+// room returns the room name for clients using f.
+func (f deviceFilter) room() string {
+	if f == (deviceFilter{}) {
+		return globalRoom
+	}
+	v := url.Values{}
+	if f.Channel != "" {
+		v.Set("channel", f.Channel)
+	}
+	if f.Tag != "" {
+		v.Set("tag", f.Tag)
+	}
+	if f.BBox != (bbox{}) {
+		v.Set("bbox", f.BBox.String())
+	}
+	if f.ExcludeOffline {
+		v.Set("include_offline", "false")
+	}
+	return filterRoomPrefix + v.Encode()
+}
+
+// roomFilter returns the filter encoded in a room name. The global room and
+// unknown rooms match every device.
+func roomFilter(room string) deviceFilter {
+	encoded, ok := strings.CutPrefix(room, filterRoomPrefix)
+	if !ok {
+		return deviceFilter{}
+	}
+	q, err := url.ParseQuery(encoded)
+	if err != nil {
+		return deviceFilter{}
+	}
+	f, err := filterFromQuery(q, false)
+	if err != nil {
+		return deviceFilter{}
+	}
+	return f
+}
+
+func (f deviceFilter) match(v DeviceView) bool {
+	if f.Channel != "" && v.Channel != f.Channel {
+		return false
+	}
+	if f.Tag != "" && !slices.Contains(v.Tags, f.Tag) {
+		return false
+	}
+	if f.ExcludeOffline && !v.Online {
+		return false
+	}
+	if f.BBox != (bbox{}) && (!hasFix(v) || !f.BBox.contains(v.Lat, v.Lon)) {
+		return false
+	}
+	return true
+}
+
+// apply returns the views matching f, or views itself if f matches all.
+func (f deviceFilter) apply(views []DeviceView) []DeviceView {
+	if f == (deviceFilter{}) {
+		return views
+	}
+	filtered := make([]DeviceView, 0, len(views))
+	for _, v := range views {
+		if f.match(v) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// deviceSort returns the comparison for ?sort= and ?order=, or nil to keep
+// the default most-recently-seen-first order. last_seen sorts newest first
+// unless order=asc; other fields sort ascending unless order=desc.
+func deviceSort(field, order string) (func(a, b DeviceView) int, error) {
+	var compare func(a, b DeviceView) int
+	desc := false
+	switch field {
+	case "":
+		if order != "" {
+			return nil, fmt.Errorf("order requires sort")
+		}
+		return nil, nil
+	case "last_seen":
+		compare = func(a, b DeviceView) int { return a.LastSeen.Compare(b.LastSeen) }
+		desc = true
+	case "battery":
+		compare = func(a, b DeviceView) int { return cmp.Compare(a.BatteryLevel, b.BatteryLevel) }
+	case "id":
+		compare = func(a, b DeviceView) int { return cmp.Compare(a.ID, b.ID) }
+	default:
+		return nil, fmt.Errorf("invalid sort %q: want last_seen, battery or id", field)
+	}
+
+	switch order {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		return nil, fmt.Errorf("invalid order %q: want asc or desc", order)
+	}
+	if desc {
+		return func(a, b DeviceView) int { return compare(b, a) }, nil
+	}
+	return compare, nil
+}
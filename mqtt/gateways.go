@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// bestGatewayWindow limits the best-reception choice to gateways that heard
+// the device within this long of its most recent reception, so a gateway
+// that heard it well hours ago does not mask current conditions.
+const bestGatewayWindow = 15 * time.Minute
+
+// GatewaySample is the reception of a device by one MQTT gateway.
+type GatewaySample struct {
+	Gateway  string    `json:"gateway"`
+	RSSI     float64   `json:"rssi"`
+	SNR      float64   `json:"snr"`
+	HopsAway int64     `json:"hops_away"`
+	HeardAt  time.Time `json:"heard_at"`
+}
+
+// packetGateway returns the node that uplinked a packet to MQTT: the
+// envelope's sender, or the last topic segment if that is missing.
+func packetGateway(topic string, pkt MeshtasticPacket) string {
+	if pkt.Sender != "" {
+		return pkt.Sender
+	}
+	return topic[strings.LastIndex(topic, "/")+1:]
+}
+
+// recordGatewaySample stores the reception metadata the gateway added to a
+// packet. Packets without any are ignored.
+func (s *Subscriber) recordGatewaySample(info packetInfo, pkt MeshtasticPacket) {
+	if pkt.RSSI == 0 && pkt.SNR == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "id", info.id, "err", err)
+		return
+	}
+	defer release()
+
+	err = s.queries.UpsertGatewaySample(ctx, db.UpsertGatewaySampleParams{
+		DeviceID: info.id,
+		Gateway:  packetGateway(info.topic, pkt),
+		Rssi:     pkt.RSSI,
+		Snr:      pkt.SNR,
+		HopsAway: pkt.HopsAway,
+	})
+	if err != nil {
+		slog.Error("failed to store gateway sample", "id", info.id, "err", err)
+	}
+}
+
+// GatewaySamples returns the latest reception of a device by each gateway,
+// best SNR first.
+func (s *Subscriber) GatewaySamples(ctx context.Context, id string) ([]GatewaySample, error) {
+	rows, err := s.queries.ListGatewaySamplesForDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]GatewaySample, 0, len(rows))
+	for _, r := range rows {
+		samples = append(samples, gatewaySampleFromRow(r))
+	}
+	return samples, nil
+}
+
+// gatewayReception summarises which gateways recently heard a device.
+type gatewayReception struct {
+	Best  GatewaySample
+	Count int
+}
+
+// bestGateways picks, per device, the highest-SNR sample among those heard
+// within bestGatewayWindow of the device's latest sample, and counts the
+// gateways in that window. rows must be ordered by device and descending
+// SNR.
+func bestGateways(rows []db.GatewaySample) map[string]gatewayReception {
+	latest := make(map[string]time.Time)
+	for _, r := range rows {
+		if r.HeardAt.After(latest[r.DeviceID]) {
+			latest[r.DeviceID] = r.HeardAt
+		}
+	}
+
+	reception := make(map[string]gatewayReception)
+	for _, r := range rows {
+		if r.HeardAt.Before(latest[r.DeviceID].Add(-bestGatewayWindow)) {
+			continue
+		}
+		rec, ok := reception[r.DeviceID]
+		if !ok {
+			rec.Best = gatewaySampleFromRow(r)
+		}
+		rec.Count++
+		reception[r.DeviceID] = rec
+	}
+	return reception
+}
+
+func gatewaySampleFromRow(r db.GatewaySample) GatewaySample {
+	return GatewaySample{
+		Gateway:  r.Gateway,
+		RSSI:     r.Rssi,
+		SNR:      r.Snr,
+		HopsAway: r.HopsAway,
+		HeardAt:  r.HeardAt.UTC(),
+	}
+}
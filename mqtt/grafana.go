@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Grafana metrics, as offered by the JSON datasource search endpoint. The
+// telemetry metrics return one series per device, or a single device's
+// series when suffixed with ":<id>".
+const (
+	grafanaBattery       = "battery"
+	grafanaVoltage       = "voltage"
+	grafanaTemperature   = "temperature"
+	grafanaDevicesOnline = "devices_online"
+	grafanaDevices       = "devices"
+)
+
+var grafanaMetrics = []string{grafanaBattery, grafanaVoltage, grafanaTemperature, grafanaDevicesOnline, grafanaDevices}
+
+// validGrafanaTarget reports whether target names a metric, optionally
+// restricted to one device for the telemetry metrics.
+func validGrafanaTarget(target string) bool {
+	metric, id, found := strings.Cut(target, ":")
+	switch metric {
+	case grafanaBattery, grafanaVoltage, grafanaTemperature:
+		return !found || id != ""
+	case grafanaDevicesOnline, grafanaDevices:
+		return !found
+	}
+	return false
+}
+
+// grafanaQuery is the body of a JSON datasource /query request.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// grafanaSeries is a time series response: datapoints are [value, unix ms].
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// GrafanaQuery answers a JSON datasource query with a series per device for
+// the telemetry metrics, the current online count and a table of devices.
+// Targets must have been checked with validGrafanaTarget.
+func (s *Subscriber) GrafanaQuery(ctx context.Context, q grafanaQuery, now time.Time) ([]any, error) {
+	if q.Range.To.IsZero() || q.Range.To.After(now) {
+		q.Range.To = now
+	}
+	step := time.Duration(q.IntervalMs) * time.Millisecond
+
+	views, err := s.ListViews(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []any{}
+	for _, t := range q.Targets {
+		metric, id, _ := strings.Cut(t.Target, ":")
+		switch metric {
+		case grafanaBattery, grafanaVoltage, grafanaTemperature:
+			for _, v := range views {
+				if id != "" && v.ID != id {
+					continue
+				}
+				series, err := s.grafanaSeries(ctx, metric, v.ID, q.Range.From, q.Range.To, step)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, series)
+			}
+		case grafanaDevicesOnline:
+			online := 0
+			for _, v := range views {
+				if v.Online {
+					online++
+				}
+			}
+			results = append(results, grafanaSeries{
+				Target:     grafanaDevicesOnline,
+				Datapoints: [][2]float64{{float64(online), float64(q.Range.To.UnixMilli())}},
+			})
+		case grafanaDevices:
+			results = append(results, grafanaDeviceTable(views))
+		}
+	}
+	return results, nil
+}
+
+// grafanaSeries returns a device's telemetry metric between from and to,
+// downsampled to at most one point per step.
+func (s *Subscriber) grafanaSeries(ctx context.Context, metric, id string, from, to time.Time, step time.Duration) (grafanaSeries, error) {
+	points, err := s.TelemetryHistory(ctx, id, from, step)
+	if err != nil {
+		return grafanaSeries{}, err
+	}
+	series := grafanaSeries{Target: metric + ":" + id, Datapoints: [][2]float64{}}
+	for _, p := range points {
+		if p.Time.After(to) {
+			break
+		}
+		var value *float64
+		switch {
+		case metric == grafanaBattery && p.Kind == telemetryDevice:
+			value = &p.BatteryLevel
+		case metric == grafanaVoltage && p.Kind == telemetryDevice:
+			value = &p.Voltage
+		case metric == grafanaTemperature:
+			value = p.Temperature
+		}
+		if value != nil {
+			series.Datapoints = append(series.Datapoints, [2]float64{*value, float64(p.Time.UnixMilli())})
+		}
+	}
+	return series, nil
+}
+
+func grafanaDeviceTable(views []DeviceView) grafanaTable {
+	table := grafanaTable{
+		Type: "table",
+		Columns: []grafanaColumn{
+			{Text: "id", Type: "string"},
+			{Text: "name", Type: "string"},
+			{Text: "lat", Type: "number"},
+			{Text: "lon", Type: "number"},
+			{Text: "battery_level", Type: "number"},
+			{Text: "online", Type: "boolean"},
+			{Text: "last_seen", Type: "time"},
+		},
+		Rows: [][]any{},
+	}
+	for _, v := range views {
+		table.Rows = append(table.Rows, []any{v.ID, v.DisplayName, v.Lat, v.Lon, v.BatteryLevel, v.Online, v.LastSeen.UnixMilli()})
+	}
+	return table
+}
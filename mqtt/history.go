@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// Telemetry history kinds.
+const (
+	telemetryDevice      = "device"
+	telemetryEnvironment = "environment"
+)
+
+// TelemetryPoint is one entry of a device's telemetry series.
+type TelemetryPoint struct {
+	Time               time.Time `json:"time"`
+	Kind               string    `json:"kind"`
+	BatteryLevel       float64   `json:"battery_level"`
+	Voltage            float64   `json:"voltage"`
+	Temperature        *float64  `json:"temperature"`
+	RelativeHumidity   *float64  `json:"relative_humidity"`
+	BarometricPressure *float64  `json:"barometric_pressure"`
+}
+
+// recordTelemetry appends t to the telemetry history unless it matches the
+// most recent entry of the same kind.
+func (s *Subscriber) recordTelemetry(ctx context.Context, id string, t TelemetryPayload) {
+	kind := telemetryDevice
+	if t.BatteryLevel == 0 && t.Voltage == 0 {
+		kind = telemetryEnvironment
+	}
+
+	params := db.InsertTelemetryParams{
+		DeviceID:           id,
+		Kind:               kind,
+		BatteryLevel:       t.BatteryLevel,
+		Voltage:            t.Voltage,
+		Temperature:        nullFloat(t.Temperature),
+		RelativeHumidity:   nullFloat(t.RelativeHumidity),
+		BarometricPressure: nullFloat(t.BarometricPressure),
+	}
+
+	last, err := s.queries.GetLatestTelemetry(ctx, db.GetLatestTelemetryParams{DeviceID: id, Kind: kind})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		slog.Error("failed to load latest telemetry", "id", id, "err", err)
+		return
+	case last.BatteryLevel == params.BatteryLevel &&
+		last.Voltage == params.Voltage &&
+		last.Temperature == params.Temperature &&
+		last.RelativeHumidity == params.RelativeHumidity &&
+		last.BarometricPressure == params.BarometricPressure:
+		return
+	}
+
+	if err := s.queries.InsertTelemetry(ctx, params); err != nil {
+		slog.Error("failed to record telemetry history", "id", id, "err", err)
+	}
+}
+
+// TelemetryHistory returns the telemetry recorded for id since the given
+// time. A positive step downsamples the series to at most one point of each
+// kind per step.
+func (s *Subscriber) TelemetryHistory(ctx context.Context, id string, since time.Time, step time.Duration) ([]TelemetryPoint, error) {
+	rows, err := s.queries.ListTelemetrySince(ctx, db.ListTelemetrySinceParams{DeviceID: id, Since: since.UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]TelemetryPoint, 0, len(rows))
+	buckets := make(map[string]time.Time)
+	for _, r := range rows {
+		if step > 0 {
+			b := r.RecordedAt.Truncate(step)
+			if last, ok := buckets[r.Kind]; ok && b.Equal(last) {
+				continue
+			}
+			buckets[r.Kind] = b
+		}
+		points = append(points, TelemetryPoint{
+			Time:               r.RecordedAt.UTC(),
+			Kind:               r.Kind,
+			BatteryLevel:       r.BatteryLevel,
+			Voltage:            r.Voltage,
+			Temperature:        floatPtr(r.Temperature),
+			RelativeHumidity:   floatPtr(r.RelativeHumidity),
+			BarometricPressure: floatPtr(r.BarometricPressure),
+		})
+	}
+	return points, nil
+}
+
+// pruneHistory deletes history entries older than the retention window.
+func (s *Subscriber) pruneHistory(ctx context.Context) {
+	if s.opts.HistoryRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.opts.HistoryRetention).UTC()
+	if err := s.queries.DeleteTelemetryBefore(ctx, cutoff); err != nil {
+		slog.Error("failed to prune telemetry history", "err", err)
+	}
+	if err := s.queries.DeletePositionsBefore(ctx, cutoff); err != nil {
+		slog.Error("failed to prune position history", "err", err)
+	}
+}
+
+// parseSince parses a ?since= value given either as an RFC 3339 timestamp or
+// as a duration before now such as "6h". An empty value means def before now.
+func parseSince(v string, def time.Duration, now time.Time) (time.Time, error) {
+	if v == "" {
+		return now.Add(-def), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q: want RFC 3339 time or duration", v)
+	}
+	return now.Add(-d), nil
+}
+
+func nullFloat(f *float64) sql.NullFloat64 {
+	if f == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *f, Valid: true}
+}
+
+func floatPtr(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
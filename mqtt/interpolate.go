@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// trackPruneAge drops the track of a device without a fix for this long,
+// matching how long stale devices are kept.
+const trackPruneAge = 48 * time.Hour
+
+// InterpolatedPosition is an estimated position between two real fixes.
+type InterpolatedPosition struct {
+	ID           string  `json:"id"`
+	Lat          float64 `json:"lat"`
+	Lon          float64 `json:"lon"`
+	Interpolated bool    `json:"interpolated"`
+}
+
+// InterpolatedMessage carries the estimated positions of moving devices to
+// clients that negotiated the interpolate capability.
+type InterpolatedMessage struct {
+	Type string                 `json:"type"`
+	Data []InterpolatedPosition `json:"data"`
+}
+
+// track is the last real fix of a device and the velocity between it and
+// the fix before, in degrees per second.
+type track struct {
+	view       DeviceView
+	fixAt      time.Time
+	vLat, vLon float64
+}
+
+// Interpolator extrapolates the positions of moving devices from their last
+// two fixes and broadcasts them every interval, for at most maxAge after a
+// real fix. Estimates are quantized to precision meters like device views.
+type Interpolator struct {
+	cm        *ConnectionManager
+	interval  time.Duration
+	maxAge    time.Duration
+	precision float64
+
+	mu     sync.Mutex
+	tracks map[string]*track
+}
+
+func NewInterpolator(cm *ConnectionManager, interval, maxAge time.Duration, precision float64) *Interpolator {
+	return &Interpolator{
+		cm:        cm,
+		interval:  interval,
+		maxAge:    maxAge,
+		precision: precision,
+		tracks:    make(map[string]*track),
+	}
+}
+
+// Update records a device update. A moved fix sets the velocity from the
+// previous one; other updates refresh the view but keep the motion.
+func (ip *Interpolator) Update(v DeviceView) {
+	if !hasFix(v) {
+		return
+	}
+	now := time.Now()
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+
+	t, ok := ip.tracks[v.ID]
+	if !ok {
+		ip.tracks[v.ID] = &track{view: v, fixAt: now}
+		return
+	}
+	if v.Lat != t.view.Lat || v.Lon != t.view.Lon {
+		if dt := now.Sub(t.fixAt).Seconds(); dt > 0 {
+			t.vLat = (v.Lat - t.view.Lat) / dt
+			t.vLon = (v.Lon - t.view.Lon) / dt
+		}
+		t.fixAt = now
+	}
+	if v.Override {
+		t.vLat, t.vLon = 0, 0
+	}
+	t.view = v
+}
+
+// Run broadcasts interpolated positions every interval until ctx is
+// cancelled.
+func (ip *Interpolator) Run(ctx context.Context) {
+	ticker := time.NewTicker(ip.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ip.broadcast(ip.estimate(now))
+		}
+	}
+}
+
+// estimate returns views of the devices still moving within maxAge of their
+// last fix, moved along their velocity.
+func (ip *Interpolator) estimate(now time.Time) []DeviceView {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+
+	var views []DeviceView
+	for id, t := range ip.tracks {
+		elapsed := now.Sub(t.fixAt)
+		if elapsed > trackPruneAge {
+			delete(ip.tracks, id)
+			continue
+		}
+		if elapsed > ip.maxAge || !t.view.Online || (t.vLat == 0 && t.vLon == 0) {
+			continue
+		}
+		v := t.view
+		v.Lat += t.vLat * elapsed.Seconds()
+		v.Lon += t.vLon * elapsed.Seconds()
+		views = append(views, v)
+	}
+	return views
+}
+
+// broadcast sends each room the estimated positions matching its filter.
+func (ip *Interpolator) broadcast(views []DeviceView) {
+	if len(views) == 0 {
+		return
+	}
+	for _, room := range ip.cm.Rooms() {
+		var positions []InterpolatedPosition
+		for _, v := range roomFilter(room).apply(views) {
+			lat, lon := quantizePosition(v.Lat, v.Lon, ip.precision)
+			positions = append(positions, InterpolatedPosition{ID: v.ID, Lat: lat, Lon: lon, Interpolated: true})
+		}
+		if len(positions) == 0 {
+			continue
+		}
+		msg, err := newWSMessage(InterpolatedMessage{Type: "positions", Data: positions})
+		if err != nil {
+			slog.Error("failed to marshal interpolated positions", "err", err)
+			return
+		}
+		ip.cm.BroadcastCapable(room, capabilityInterpolate, frameDelta, msg)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// maxTextBytes is the longest text message stored. Meshtastic itself limits
+// texts to about 230 bytes, so longer ones were not sent over the mesh.
+const maxTextBytes = 512
+
+// defaultMessagesLimit and maxMessagesLimit bound ?limit= of /api/messages.
+const (
+	defaultMessagesLimit = 100
+	maxMessagesLimit     = 1000
+)
+
+// TextPayload is the payload for type=text packets, chat messages sent on a
+// channel or directly to a node.
+type TextPayload struct {
+	Text string `json:"text"`
+}
+
+// handleText stores a chat message in the message log. It does not touch
+// the device itself.
+func (s *Subscriber) handleText(info packetInfo, raw json.RawMessage) {
+	var p TextPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		s.payloadError(info, "text payload", err)
+		return
+	}
+	if len(p.Text) > maxTextBytes {
+		s.payloadError(info, "text payload", fmt.Errorf("text of %d bytes exceeds %d", len(p.Text), maxTextBytes))
+		return
+	}
+	if p.Text == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "id", info.id, "err", err)
+		return
+	}
+	defer release()
+
+	err = s.queries.InsertMessage(ctx, db.InsertMessageParams{
+		NodeID:  info.id,
+		Channel: info.channel,
+		Text:    p.Text,
+	})
+	if err != nil {
+		slog.Error("failed to store text message", "id", info.id, "err", err)
+		return
+	}
+	slog.Debug("text message stored", "id", info.id, "bytes", len(p.Text))
+}
+
+// RecentMessages returns up to limit text messages, newest first.
+func (s *Subscriber) RecentMessages(ctx context.Context, limit int64) ([]db.Message, error) {
+	messages, err := s.queries.ListRecentMessages(ctx, limit)
+	if messages == nil {
+		messages = []db.Message{}
+	}
+	return messages, err
+}
+
+// pruneMessages deletes the oldest text messages beyond MessagesMax.
+func (s *Subscriber) pruneMessages(ctx context.Context) {
+	if s.opts.MessagesMax <= 0 {
+		return
+	}
+	if err := s.queries.DeleteMessagesOverCount(ctx, s.opts.MessagesMax); err != nil {
+		slog.Error("failed to prune text messages", "err", err)
+	}
+}
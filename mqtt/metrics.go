@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mqtt_messages_received_total",
+		Help: "MQTT messages delivered to the subscriber, on any topic.",
+	})
+	packetsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "meshtastic_packets_received_total",
+		Help: "Meshtastic JSON packets parsed, by packet type. Unknown types are counted as \"other\".",
+	}, []string{"type"})
+	parseErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "meshtastic_parse_errors_total",
+		Help: "Messages and payloads dropped because they failed to parse or validate, by kind.",
+	}, []string{"kind"})
+	wsBytesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_bytes_sent_total",
+		Help: "Message bytes written to WebSocket clients, as encoded.",
+	})
+	wsFramesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_frames_dropped_total",
+		Help: "Frames discarded for WebSocket clients whose send queue overflowed.",
+	})
+)
+
+// countPacket counts a parsed packet under its type, keeping the label set
+// bounded by folding unknown types into "other".
+func countPacket(t string) {
+	if !slices.Contains(packetTypes, t) {
+		t = "other"
+	}
+	packetsReceived.WithLabelValues(t).Inc()
+}
+
+// registerMetrics registers the metrics read from cm, sub and broker at
+// scrape time.
+func registerMetrics(cm *ConnectionManager, sub *Subscriber, broker *Broker) {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "mqtt_messages_dropped_total",
+		Help: "MQTT messages dropped because a worker queue was full.",
+	}, func() float64 {
+		return float64(broker.Dropped())
+	})
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "meshtastic_positions_sampled_total",
+		Help: "Position packets dropped by load sampling while a worker queue was above the high-water mark.",
+	}, func() float64 {
+		return float64(broker.Sampled())
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "websocket_clients",
+		Help: "Connected WebSocket clients.",
+	}, func() float64 {
+		return float64(cm.Count())
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "devices",
+		Help: "Devices stored in the database.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		n, err := sub.CountDevices(ctx)
+		if err != nil {
+			slog.Warn("failed to count devices for metrics", "err", err)
+			return math.NaN()
+		}
+		return float64(n)
+	})
+}
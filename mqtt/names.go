@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// NodeInfoPayload is the payload for type=nodeinfo packets.
+type NodeInfoPayload struct {
+	ID        string `json:"id"`
+	LongName  string `json:"longname"`
+	ShortName string `json:"shortname"`
+	Hardware  int64  `json:"hardware"`
+	// Role is empty when the firmware does not report it.
+	Role nodeRole `json:"role"`
+}
+
+// sanitizeName cleans a reported node name for storage and display: control
+// characters are removed and surrounding whitespace is trimmed. Invalid UTF-8
+// needs no handling, as the JSON decoder has already replaced it with U+FFFD.
+func sanitizeName(name string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+}
+
+func (s *Subscriber) handleNodeInfo(info packetInfo, raw json.RawMessage) {
+	id := info.id
+	var n NodeInfoPayload
+	if err := json.Unmarshal(raw, &n); err != nil {
+		s.payloadError(info, "nodeinfo payload", err)
+		return
+	}
+	longName, shortName := sanitizeName(n.LongName), sanitizeName(n.ShortName)
+	if longName != n.LongName || shortName != n.ShortName {
+		slog.Debug("sanitized node names", "id", id, "long_name", longName, "short_name", shortName)
+		n.LongName, n.ShortName = longName, shortName
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "id", id, "err", err)
+		return
+	}
+	defer release()
+
+	if n.ShortName != "" {
+		others, err := s.queries.ListDevicesWithShortName(ctx, db.ListDevicesWithShortNameParams{ShortName: n.ShortName, ID: id})
+		if err != nil {
+			slog.Warn("failed to check short name collisions", "id", id, "err", err)
+		} else if len(others) > 0 {
+			slog.Debug("short name collision", "id", id, "short_name", n.ShortName, "others", others)
+		}
+	}
+
+	// The previous channel lets clients of that channel see the device
+	// leave; a device not stored yet has none.
+	prev, _ := s.queries.GetDevice(ctx, id)
+	device, err := s.queries.SetDeviceNames(ctx, db.SetDeviceNamesParams{
+		ID:        id,
+		LongName:  n.LongName,
+		ShortName: n.ShortName,
+		Channel:   info.channel,
+		Role:      string(n.Role),
+	})
+	if err != nil {
+		slog.Error("failed to store node info", "id", id, "err", err)
+		return
+	}
+
+	slog.Info("node info updated", "id", id, "long_name", n.LongName, "short_name", n.ShortName, "role", n.Role)
+	if s.isPending(id) {
+		return
+	}
+	s.notifyUpdate(ctx, device, release)
+	s.broadcastDevice(device, prev.Channel)
+}
+
+// disambiguateNames sets DisplayName on views whose short name is shared with
+// another device by appending the last two hex digits of the node ID. The
+// stored short name is left untouched.
+func disambiguateNames(views []DeviceView) {
+	counts := make(map[string]int)
+	for _, v := range views {
+		if v.ShortName != "" {
+			counts[v.ShortName]++
+		}
+	}
+	for i, v := range views {
+		if counts[v.ShortName] > 1 && len(v.ID) >= 2 {
+			views[i].DisplayName = v.ShortName + "-" + v.ID[len(v.ID)-2:]
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Base Camp", "Base Camp"},
+		{"empty", "", ""},
+		{"surrounding whitespace", "  Base Camp \t", "Base Camp"},
+		{"control characters", "Base\x00 Ca\x1bmp\x7f", "Base Camp"},
+		{"newline", "Base\nCamp", "BaseCamp"},
+		{"c1 control", "Base\u0085Camp", "BaseCamp"},
+		{"replacement character kept", "Base �", "Base �"},
+		{"emoji kept", "🏕 Camp", "🏕 Camp"},
+		{"only controls", "\x01\x02", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeName(tt.in); got != tt.want {
+				t.Errorf("sanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// packetTypes lists the packet types HandleMessage knows how to process.
+var packetTypes = []string{"position", "telemetry", "nodeinfo", "mapreport", "traceroute", "routing", "text"}
+
+// parsePacketTypes parses a comma-separated list of packet types to process.
+func parsePacketTypes(s string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if !slices.Contains(packetTypes, t) {
+			return nil, fmt.Errorf("unknown packet type %q: want one of %s", t, strings.Join(packetTypes, ", "))
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// packetTypeFilter decides which packet types are dispatched and counts the
+// ones it ignores. Unknown types are counted as "other" so publishers can't
+// grow the counts without bound.
+type packetTypeFilter struct {
+	allowed []string
+
+	mu      sync.Mutex
+	ignored map[string]uint64
+}
+
+func newPacketTypeFilter(allowed []string) *packetTypeFilter {
+	return &packetTypeFilter{allowed: allowed, ignored: make(map[string]uint64)}
+}
+
+// accept reports whether packets of type t are processed. A nil allowlist
+// accepts every known type.
+func (f *packetTypeFilter) accept(t string) bool {
+	if slices.Contains(packetTypes, t) && (f.allowed == nil || slices.Contains(f.allowed, t)) {
+		return true
+	}
+	key := t
+	if !slices.Contains(packetTypes, key) {
+		key = "other"
+	}
+	f.mu.Lock()
+	f.ignored[key]++
+	n := f.ignored[key]
+	f.mu.Unlock()
+	slog.Debug("ignoring packet type", "type", t, "count", n)
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// parseErrorTracker rate-limits the logging of parse failures per topic;
+// totals are counted by meshtastic_parse_errors_total. The first failure in
+// each window is logged as-is; the rest are summarised in a single warning
+// when the next window starts, so a gateway sending malformed data
+// continuously doesn't flood the log.
+type parseErrorTracker struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	topics map[string]*topicErrors
+}
+
+type topicErrors struct {
+	windowStart time.Time
+	inWindow    int
+	lastErr     error
+}
+
+func newParseErrorTracker(window time.Duration) *parseErrorTracker {
+	return &parseErrorTracker{window: window, topics: make(map[string]*topicErrors)}
+}
+
+// Record counts a parse failure of kind ("packet", "position", ...) on topic.
+func (t *parseErrorTracker) Record(topic, kind string, err error) {
+	parseErrorsTotal.WithLabelValues(kind).Inc()
+	now := time.Now()
+
+	t.mu.Lock()
+	te, ok := t.topics[topic]
+	if !ok {
+		te = &topicErrors{}
+		t.topics[topic] = te
+	}
+
+	var summary int
+	var summaryErr error
+	if now.Sub(te.windowStart) > t.window {
+		summary, summaryErr = te.inWindow, te.lastErr
+		te.windowStart = now
+		te.inWindow = 0
+	}
+	te.inWindow++
+	te.lastErr = err
+	first := te.inWindow == 1
+	t.mu.Unlock()
+
+	// The first error of the previous window was already logged.
+	if summary > 1 {
+		slog.Warn("repeated parse errors on topic", "topic", topic, "count", summary, "window", t.window.String(), "last_err", summaryErr)
+	}
+	if first {
+		slog.Warn("failed to parse "+kind, "topic", topic, "err", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// pendingTracker holds devices reporting their first fix back from snapshots
+// and update hooks until they report a stable position: MinFixes consecutive
+// fixes each within Radius meters of the one before. Devices without a fix,
+// such as sensors, are never held. Pending state is kept in memory, so
+// devices with a stored fix when the server starts are never held.
+type pendingTracker struct {
+	minFixes int
+	radius   float64
+
+	mu      sync.Mutex
+	devices map[string]*pendingDevice
+}
+
+type pendingDevice struct {
+	// lastAt is when the device last reported a fix.
+	lastAt   time.Time
+	fixes    int
+	lat, lon float64
+}
+
+func newPendingTracker(minFixes int, radius float64) *pendingTracker {
+	return &pendingTracker{
+		minFixes: minFixes,
+		radius:   radius,
+		devices:  make(map[string]*pendingDevice),
+	}
+}
+
+// hold starts holding a device reporting its first fix.
+func (p *pendingTracker) hold(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.devices[id]; !ok {
+		p.devices[id] = &pendingDevice{lastAt: time.Now()}
+		slog.Debug("holding new device until its position is stable", "id", id)
+	}
+}
+
+// observe records a fix for a held device and reports whether the device is
+// still pending afterwards.
+func (p *pendingTracker) observe(id string, lat, lon float64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.devices[id]
+	if !ok {
+		return false
+	}
+
+	if d.fixes > 0 && haversineMeters(d.lat, d.lon, lat, lon) <= p.radius {
+		d.fixes++
+	} else {
+		d.fixes = 1
+	}
+	d.lat, d.lon = lat, lon
+	d.lastAt = time.Now()
+
+	if d.fixes < p.minFixes {
+		return true
+	}
+	delete(p.devices, id)
+	slog.Info("new device position confirmed", "id", id, "fixes", d.fixes)
+	return false
+}
+
+func (p *pendingTracker) isPending(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.devices[id]
+	return ok
+}
+
+// prune forgets held devices that have not reported a fix since cutoff.
+// Devices that keep reporting stay held however long they go without a
+// stable position.
+func (p *pendingTracker) prune(cutoff time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, d := range p.devices {
+		if d.lastAt.Before(cutoff) {
+			delete(p.devices, id)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingPruneKeepsReportingDevices(t *testing.T) {
+	p := newPendingTracker(3, 200)
+	p.hold("!00000001")
+	p.hold("!00000002")
+
+	// Fixes far apart keep the first device pending while it reports.
+	time.Sleep(10 * time.Millisecond)
+	p.observe("!00000001", 51.5, -0.1)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	if !p.observe("!00000001", 52.5, -0.1) {
+		t.Fatal("device released without a stable fix")
+	}
+
+	p.prune(cutoff)
+	if !p.isPending("!00000001") {
+		t.Error("device still reporting lost its hold")
+	}
+	if p.isPending("!00000002") {
+		t.Error("silent device is still held after prune")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// Packet types that carry a position.
+const (
+	PositionSourcePosition  = "position"
+	PositionSourceMapReport = "mapreport"
+)
+
+// MapReportPayload is the position part of the payload for type=mapreport
+// packets, which nodes with map reporting enabled send alongside or instead
+// of regular position packets.
+type MapReportPayload struct {
+	LatitudeI  int64    `json:"latitude_i"`
+	LongitudeI int64    `json:"longitude_i"`
+	Altitude   *float64 `json:"altitude"`
+}
+
+func (s *Subscriber) handleMapReport(info packetInfo, raw json.RawMessage) {
+	var m MapReportPayload
+	if err := json.Unmarshal(raw, &m); err != nil {
+		s.payloadError(info, "mapreport payload", err)
+		return
+	}
+	s.updatePosition(info, PositionPayload{
+		LatitudeI:  m.LatitudeI,
+		LongitudeI: m.LongitudeI,
+		Altitude:   m.Altitude,
+	}, PositionSourceMapReport)
+}
+
+// parsePositionSources parses a comma-separated priority list of position
+// sources, highest first.
+func parsePositionSources(s string) ([]string, error) {
+	var sources []string
+	for _, src := range strings.Split(s, ",") {
+		src = strings.TrimSpace(src)
+		switch src {
+		case PositionSourcePosition, PositionSourceMapReport:
+		default:
+			return nil, fmt.Errorf("unknown position source %q: want %s or %s", src, PositionSourcePosition, PositionSourceMapReport)
+		}
+		if slices.Contains(sources, src) {
+			return nil, fmt.Errorf("duplicate position source %q", src)
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// sourceRank returns the priority of a position source, lower is preferred.
+// Unlisted sources rank last.
+func (s *Subscriber) sourceRank(source string) int {
+	if i := slices.Index(s.opts.PositionSources, source); i >= 0 {
+		return i
+	}
+	return len(s.opts.PositionSources)
+}
+
+// outranked reports whether the device's stored position came from a
+// higher-priority source than source recently enough that a position from
+// source should be ignored.
+func (s *Subscriber) outranked(prev db.Device, source string, now time.Time) bool {
+	if s.opts.PositionSourceWindow <= 0 || prev.PositionSource == "" || (prev.Lat == 0 && prev.Lon == 0) {
+		return false
+	}
+	if s.sourceRank(source) <= s.sourceRank(prev.PositionSource) {
+		return false
+	}
+	return now.Sub(prev.PositionAt) < s.opts.PositionSourceWindow
+}
@@ -0,0 +1,33 @@
+package main
+
+import "math"
+
+// metersPerDegree is the length of a degree of latitude, close enough for
+// snapping positions to a grid.
+const metersPerDegree = 111320.0
+
+// quantizePosition snaps lat/lon to the center of a grid cell about
+// precision meters on a side, so public views do not reveal exact
+// locations. Cells are fixed, so repeated fixes from the same spot always
+// land in the same cell and cannot be averaged back to the true position.
+// A zero precision or a position without a fix is returned unchanged.
+func quantizePosition(lat, lon, precision float64) (float64, float64) {
+	if precision <= 0 || (lat == 0 && lon == 0) {
+		return lat, lon
+	}
+	latStep := precision / metersPerDegree
+	qLat := (math.Floor(lat/latStep) + 0.5) * latStep
+	qLat = math.Max(-90, math.Min(90, qLat))
+	// Longitude cells narrow towards the poles; widen them to keep them
+	// about precision meters across, capped at a quarter of the globe.
+	lonStep := math.Min(latStep/math.Max(math.Cos(qLat*math.Pi/180), 0.01), 90)
+	qLon := (math.Floor(lon/lonStep) + 0.5) * lonStep
+	qLon = math.Max(-180, math.Min(180, qLon))
+	// Round to the 1e-7 degree resolution positions are reported in.
+	return math.Round(qLat*1e7) / 1e7, math.Round(qLon*1e7) / 1e7
+}
+
+// publicPosition returns lat/lon quantized to PublicPrecision.
+func (s *Subscriber) publicPosition(lat, lon float64) (float64, float64) {
+	return quantizePosition(lat, lon, s.opts.PublicPrecision)
+}
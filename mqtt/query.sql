@@ -1,6 +1,6 @@
 -- name: UpsertDevice :one
-INSERT INTO devices (id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, last_seen)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+INSERT INTO devices (id, lat, lon, alt, speed, course, sats, hdop, battery_mv, rssi, snr, online, channel, rtc_unset, position_at, position_source, course_source, battery_voltage, last_seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 ON CONFLICT(id) DO UPDATE SET
     lat        = excluded.lat,
     lon        = excluded.lon,
@@ -13,6 +13,12 @@ ON CONFLICT(id) DO UPDATE SET
     rssi       = excluded.rssi,
     snr        = excluded.snr,
     online     = excluded.online,
+    channel    = excluded.channel,
+    rtc_unset  = excluded.rtc_unset,
+    position_at = excluded.position_at,
+    position_source = excluded.position_source,
+    course_source = excluded.course_source,
+    battery_voltage = excluded.battery_voltage,
     last_seen  = CURRENT_TIMESTAMP
 RETURNING *;
 
@@ -22,8 +28,175 @@ SELECT * FROM devices ORDER BY last_seen DESC;
 -- name: MarkDeviceOffline :exec
 UPDATE devices SET online = 0 WHERE id = ?;
 
+-- name: ReconcileDevicesOnline :execrows
+-- Sets online from last_seen: devices seen since cutoff are online, the rest offline.
+UPDATE devices SET online = (last_seen >= datetime(sqlc.arg(cutoff)))
+WHERE online != (last_seen >= datetime(sqlc.arg(cutoff)));
+
 -- name: GetDevice :one
 SELECT * FROM devices WHERE id = ? LIMIT 1;
 
--- name: DeleteStaleDevices :exec
-DELETE FROM devices WHERE last_seen < datetime('now', '-48 hours');
+-- name: CountDevices :one
+SELECT COUNT(*) FROM devices;
+
+-- name: DeleteStaleDevices :many
+DELETE FROM devices WHERE last_seen < datetime(sqlc.arg(cutoff)) RETURNING id;
+
+-- name: InsertTelemetry :exec
+INSERT INTO telemetry_history (device_id, kind, battery_level, voltage, temperature, relative_humidity, barometric_pressure)
+VALUES (?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetLatestTelemetry :one
+SELECT * FROM telemetry_history
+WHERE device_id = ? AND kind = ?
+ORDER BY recorded_at DESC, id DESC
+LIMIT 1;
+
+-- name: ListTelemetrySince :many
+SELECT * FROM telemetry_history
+WHERE device_id = sqlc.arg(device_id) AND recorded_at >= datetime(sqlc.arg(since))
+ORDER BY recorded_at, id;
+
+-- name: DeleteTelemetryBefore :exec
+DELETE FROM telemetry_history WHERE recorded_at < datetime(sqlc.arg(cutoff));
+
+-- name: InsertPosition :exec
+INSERT INTO positions (device_id, lat, lon, alt, speed)
+VALUES (?, ?, ?, ?, ?);
+
+-- name: ListPositionsSince :many
+SELECT * FROM positions
+WHERE device_id = sqlc.arg(device_id) AND recorded_at >= datetime(sqlc.arg(since))
+ORDER BY recorded_at, id;
+
+-- name: DeletePositionsBefore :exec
+DELETE FROM positions WHERE recorded_at < datetime(sqlc.arg(cutoff));
+
+-- name: SetDevicePosition :one
+UPDATE devices
+SET lat = ?, lon = ?, alt = ?, position_override = 1
+WHERE id = ?
+RETURNING *;
+
+-- name: ClearDevicePositionOverride :one
+UPDATE devices SET position_override = 0 WHERE id = ?
+RETURNING *;
+
+-- name: ListDeviceTags :many
+SELECT * FROM device_tags ORDER BY device_id, tag;
+
+-- name: ListTagsForDevice :many
+SELECT tag FROM device_tags WHERE device_id = ? ORDER BY tag;
+
+-- name: DeleteDeviceTags :exec
+DELETE FROM device_tags WHERE device_id = ?;
+
+-- name: AddDeviceTag :exec
+INSERT OR IGNORE INTO device_tags (device_id, tag) VALUES (?, ?);
+
+-- name: UpsertDeviceEnrichment :exec
+INSERT INTO device_enrichment (device_id, owner, team, notes, enriched_at)
+VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(device_id) DO UPDATE SET
+    owner       = excluded.owner,
+    team        = excluded.team,
+    notes       = excluded.notes,
+    enriched_at = CURRENT_TIMESTAMP;
+
+-- name: GetDeviceEnrichment :one
+SELECT * FROM device_enrichment WHERE device_id = ? LIMIT 1;
+
+-- name: ListDeviceEnrichment :many
+SELECT * FROM device_enrichment ORDER BY device_id;
+
+-- name: SetDeviceNames :one
+INSERT INTO devices (id, long_name, short_name, channel, role, last_seen)
+VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(id) DO UPDATE SET
+    long_name  = excluded.long_name,
+    short_name = excluded.short_name,
+    channel    = excluded.channel,
+    role       = COALESCE(NULLIF(excluded.role, ''), devices.role),
+    last_seen  = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: MarkDeviceEnvSensor :execrows
+-- Records that a device reports environment telemetry.
+UPDATE devices SET env_sensor = 1 WHERE id = ? AND env_sensor = 0;
+
+-- name: ListDevicesWithShortName :many
+SELECT id FROM devices WHERE short_name = ? AND id != ?;
+
+-- name: InsertRawPacket :exec
+INSERT INTO raw_packets (topic, payload) VALUES (?, ?);
+
+-- name: DeleteRawPacketsOverSize :exec
+-- Deletes the oldest packets so the newest ones fit in max_bytes of payload.
+DELETE FROM raw_packets WHERE id <= (
+    SELECT id FROM (
+        SELECT id, SUM(length(payload)) OVER (ORDER BY id DESC) AS total
+        FROM raw_packets
+    )
+    WHERE total > sqlc.arg(max_bytes)
+    ORDER BY id DESC
+    LIMIT 1
+);
+
+-- name: InsertMessage :exec
+INSERT INTO messages (node_id, channel, text) VALUES (?, ?, ?);
+
+-- name: ListRecentMessages :many
+SELECT * FROM messages ORDER BY id DESC LIMIT ?;
+
+-- name: DeleteMessagesOverCount :exec
+-- Deletes the oldest messages so only the newest max_count are kept.
+DELETE FROM messages WHERE id <= (
+    SELECT id FROM messages ORDER BY id DESC LIMIT 1 OFFSET sqlc.arg(max_count)
+);
+
+-- name: UpsertGatewaySample :exec
+INSERT INTO gateway_samples (device_id, gateway, rssi, snr, hops_away, heard_at)
+VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(device_id, gateway) DO UPDATE SET
+    rssi      = excluded.rssi,
+    snr       = excluded.snr,
+    hops_away = excluded.hops_away,
+    heard_at  = CURRENT_TIMESTAMP;
+
+-- name: ListGatewaySamples :many
+SELECT * FROM gateway_samples ORDER BY device_id, snr DESC;
+
+-- name: ListGatewaySamplesForDevice :many
+SELECT * FROM gateway_samples WHERE device_id = ? ORDER BY snr DESC;
+
+-- name: DeleteStaleGatewaySamples :exec
+DELETE FROM gateway_samples WHERE heard_at < datetime('now', '-48 hours');
+
+-- name: AddRouteHop :exec
+INSERT INTO route_hops (device_id, direction, hop, node_id, snr) VALUES (?, ?, ?, ?, ?);
+
+-- name: ListRouteHops :many
+SELECT * FROM route_hops WHERE device_id = ? ORDER BY direction DESC, hop;
+
+-- name: DeleteRouteHops :exec
+DELETE FROM route_hops WHERE device_id = ?;
+
+-- name: DeleteStaleRouteHops :exec
+DELETE FROM route_hops WHERE heard_at < datetime('now', '-48 hours');
+
+-- name: AddRoutingResult :exec
+INSERT INTO routing_results (device_id, delivered) VALUES (?, ?);
+
+-- name: ListRoutingStats :many
+-- Counts the routing results of each device heard in the last 24 hours.
+SELECT device_id, CAST(SUM(delivered) AS INTEGER) AS delivered, COUNT(*) AS total
+FROM routing_results
+WHERE heard_at >= datetime('now', '-24 hours')
+GROUP BY device_id;
+
+-- name: DeleteStaleRoutingResults :exec
+DELETE FROM routing_results WHERE heard_at < datetime('now', '-24 hours');
+
+-- name: Ping :one
+-- Checks that the database answers queries.
+SELECT 1;
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// recordRawPacket stores a received message verbatim for later analysis.
+func (s *Subscriber) recordRawPacket(topic string, payload []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "topic", topic, "err", err)
+		return
+	}
+	defer release()
+
+	if err := s.queries.InsertRawPacket(ctx, db.InsertRawPacketParams{Topic: topic, Payload: payload}); err != nil {
+		slog.Error("failed to store raw packet", "topic", topic, "err", err)
+	}
+}
+
+// pruneRawPackets deletes the oldest raw packets once their payloads exceed
+// RawPacketsMaxBytes.
+func (s *Subscriber) pruneRawPackets(ctx context.Context) {
+	if !s.opts.RawPackets || s.opts.RawPacketsMaxBytes <= 0 {
+		return
+	}
+	if err := s.queries.DeleteRawPacketsOverSize(ctx, s.opts.RawPacketsMaxBytes); err != nil {
+		slog.Error("failed to prune raw packets", "err", err)
+	}
+}
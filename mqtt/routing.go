@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// routingErrorNone is the routing error reason of a successful delivery.
+const routingErrorNone = 0
+
+// RoutingPayload is the payload for type=routing packets, the acks and naks
+// nodes send for packets that requested one. ErrorReason is the Meshtastic
+// Routing.Error code, zero for an ack.
+type RoutingPayload struct {
+	ErrorReason int64 `json:"error_reason"`
+}
+
+// handleRouting records whether the sending device reported a delivery or a
+// failure. It does not touch the device itself, and the reliability it
+// feeds is picked up by the next broadcast.
+func (s *Subscriber) handleRouting(info packetInfo, raw json.RawMessage) {
+	var p RoutingPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		s.payloadError(info, "routing payload", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "id", info.id, "err", err)
+		return
+	}
+	defer release()
+
+	err = s.queries.AddRoutingResult(ctx, db.AddRoutingResultParams{
+		DeviceID:  info.id,
+		Delivered: boolToInt(p.ErrorReason == routingErrorNone),
+	})
+	if err != nil {
+		slog.Error("failed to store routing result", "id", info.id, "err", err)
+		return
+	}
+	slog.Debug("routing result stored", "id", info.id, "error_reason", p.ErrorReason)
+}
+
+// deviceReliability returns the percentage of each device's routing results
+// in the last 24 hours that reported a delivery.
+func deviceReliability(stats []db.ListRoutingStatsRow) map[string]float64 {
+	reliability := make(map[string]float64, len(stats))
+	for _, st := range stats {
+		if st.Total > 0 {
+			reliability[st.DeviceID] = 100 * float64(st.Delivered) / float64(st.Total)
+		}
+	}
+	return reliability
+}
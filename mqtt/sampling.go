@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// queueSampler is the last line of defence against a publish flood: once a
+// worker queue fills past the high-water mark it drops a growing fraction of
+// position packets, rising linearly to maxDrop as the queue reaches capacity.
+// Telemetry, nodeinfo and other packets are never sampled. Sampling stops
+// once the queue has drained to half the high-water mark.
+type queueSampler struct {
+	worker    int
+	highWater int
+	capacity  int
+	maxDrop   float64
+	sampled   *atomic.Uint64
+
+	mu     sync.Mutex
+	active bool
+	peak   float64
+	count  uint64
+}
+
+// samplingLogStep is how much the drop fraction must rise while sampling
+// before it is logged again.
+const samplingLogStep = 0.1
+
+// drop reports whether payload should be dropped with the queue depth at
+// depth, and records the drop if so.
+func (q *queueSampler) drop(depth int, payload []byte) bool {
+	if q.highWater <= 0 || q.capacity <= q.highWater {
+		return false
+	}
+	rate := q.rate(depth)
+	if !q.update(depth, rate) || rate == 0 || !isPositionPacket(payload) || rand.Float64() >= rate {
+		return false
+	}
+	q.sampled.Add(1)
+	q.mu.Lock()
+	q.count++
+	q.mu.Unlock()
+	return true
+}
+
+// drained is called by the worker after taking a message off the queue so
+// that recovery is noticed even when publishing stops.
+func (q *queueSampler) drained(depth int) {
+	if q.highWater > 0 {
+		q.update(depth, q.rate(depth))
+	}
+}
+
+// rate returns the fraction of position packets to drop at depth.
+func (q *queueSampler) rate(depth int) float64 {
+	if depth <= q.highWater {
+		return 0
+	}
+	rate := q.maxDrop * float64(depth-q.highWater) / float64(q.capacity-q.highWater)
+	return math.Round(rate*100) / 100
+}
+
+// update tracks whether sampling is active, logging when it starts, when
+// the drop fraction rises and when the queue recovers. It reports whether
+// sampling is active.
+func (q *queueSampler) update(depth int, rate float64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case !q.active && rate > 0:
+		q.active, q.peak, q.count = true, rate, 0
+		slog.Warn("MQTT queue over high-water mark, sampling position packets", "worker", q.worker, "depth", depth, "drop_fraction", rate)
+	case q.active && rate >= q.peak+samplingLogStep:
+		q.peak = rate
+		slog.Warn("MQTT queue still filling, sampling more position packets", "worker", q.worker, "depth", depth, "drop_fraction", rate)
+	case q.active && depth <= q.highWater/2:
+		q.active = false
+		slog.Info("MQTT queue recovered, stopped sampling", "worker", q.worker, "depth", depth, "peak_drop_fraction", q.peak, "sampled", q.count, "total_sampled", q.sampled.Load())
+	}
+	return q.active
+}
+
+// isPositionPacket reports whether payload is a Meshtastic position packet.
+// Unparseable payloads are not sampled so they still reach the parse error
+// tracking.
+func isPositionPacket(payload []byte) bool {
+	var pkt struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(payload, &pkt) == nil && pkt.Type == "position"
+}
@@ -12,5 +12,99 @@ CREATE TABLE IF NOT EXISTS devices (
     snr         REAL NOT NULL DEFAULT 0,
     online      INTEGER NOT NULL DEFAULT 1,
     last_seen   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    channel     TEXT NOT NULL DEFAULT '',
+    rtc_unset   INTEGER NOT NULL DEFAULT 0,
+    position_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    position_override INTEGER NOT NULL DEFAULT 0,
+    long_name   TEXT NOT NULL DEFAULT '',
+    short_name  TEXT NOT NULL DEFAULT '',
+    position_source TEXT NOT NULL DEFAULT '',
+    course_source TEXT NOT NULL DEFAULT '',
+    battery_voltage REAL NOT NULL DEFAULT 0,
+    role        TEXT NOT NULL DEFAULT '',
+    env_sensor  INTEGER NOT NULL DEFAULT 0
 );
+
+CREATE TABLE IF NOT EXISTS telemetry_history (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id           TEXT NOT NULL,
+    kind                TEXT NOT NULL,
+    battery_level       REAL NOT NULL DEFAULT 0,
+    voltage             REAL NOT NULL DEFAULT 0,
+    temperature         REAL,
+    relative_humidity   REAL,
+    barometric_pressure REAL,
+    recorded_at         DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS telemetry_history_device_time ON telemetry_history (device_id, recorded_at);
+
+CREATE TABLE IF NOT EXISTS device_tags (
+    device_id TEXT NOT NULL,
+    tag       TEXT NOT NULL,
+    PRIMARY KEY (device_id, tag)
+);
+
+CREATE TABLE IF NOT EXISTS raw_packets (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    topic       TEXT NOT NULL,
+    payload     BLOB NOT NULL,
+    received_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    node_id     TEXT NOT NULL,
+    channel     TEXT NOT NULL DEFAULT '',
+    text        TEXT NOT NULL,
+    received_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS gateway_samples (
+    device_id TEXT NOT NULL,
+    gateway   TEXT NOT NULL,
+    rssi      REAL NOT NULL DEFAULT 0,
+    snr       REAL NOT NULL DEFAULT 0,
+    hops_away INTEGER NOT NULL DEFAULT 0,
+    heard_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (device_id, gateway)
+);
+
+CREATE TABLE IF NOT EXISTS route_hops (
+    device_id TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    hop       INTEGER NOT NULL,
+    node_id   TEXT NOT NULL,
+    snr       REAL,
+    heard_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (device_id, direction, hop)
+);
+
+CREATE TABLE IF NOT EXISTS routing_results (
+    device_id TEXT NOT NULL,
+    delivered INTEGER NOT NULL,
+    heard_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS routing_results_device_time ON routing_results (device_id, heard_at);
+
+CREATE TABLE IF NOT EXISTS device_enrichment (
+    device_id   TEXT PRIMARY KEY,
+    owner       TEXT NOT NULL DEFAULT '',
+    team        TEXT NOT NULL DEFAULT '',
+    notes       TEXT NOT NULL DEFAULT '',
+    enriched_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS positions (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id   TEXT NOT NULL,
+    lat         REAL NOT NULL,
+    lon         REAL NOT NULL,
+    alt         REAL NOT NULL DEFAULT 0,
+    speed       REAL NOT NULL DEFAULT 0,
+    recorded_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS positions_device_time ON positions (device_id, recorded_at);
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// loadTestReportInterval is how often a load test logs its throughput.
+const loadTestReportInterval = 5 * time.Second
+
+// loadTestOptions configures simulate -load-test.
+type loadTestOptions struct {
+	host               string
+	port               int
+	username, password string
+	count              int
+	region, channel    string
+	// rate is the target total messages per second; zero publishes as
+	// fast as the devices can.
+	rate float64
+	// rampStep raises rate every rampInterval, to find the point where the
+	// achieved rate stops following the target.
+	rampStep     float64
+	rampInterval time.Duration
+	// duration stops the test; zero runs until interrupted.
+	duration  time.Duration
+	locations [][2]float64
+}
+
+func (o loadTestOptions) validate() error {
+	switch {
+	case o.count < 1:
+		return errors.New("--count must be at least 1")
+	case o.rate < 0:
+		return errors.New("--rate must not be negative")
+	case o.rampStep < 0:
+		return errors.New("--ramp-step must not be negative")
+	case o.rampStep > 0 && o.rate == 0:
+		return errors.New("--ramp-step needs a starting --rate")
+	case o.rampStep > 0 && o.rampInterval <= 0:
+		return errors.New("--ramp-interval must be positive")
+	case o.duration < 0:
+		return errors.New("--duration must not be negative")
+	}
+	return nil
+}
+
+// loadTest counts what a running load test has published.
+type loadTest struct {
+	published atomic.Uint64
+	failed    atomic.Uint64
+	// missed counts sends the pacer scheduled while every device was still
+	// busy publishing, i.e. the target rate was out of reach.
+	missed atomic.Uint64
+	// target holds the current target rate as float64 bits.
+	target atomic.Uint64
+}
+
+// loadTestDevice is a connected simulated device.
+type loadTestDevice struct {
+	nodeNum uint32
+	client  pahomqtt.Client
+}
+
+func (lt *loadTest) targetRate() float64 {
+	return math.Float64frombits(lt.target.Load())
+}
+
+func (lt *loadTest) setTargetRate(rate float64) {
+	lt.target.Store(math.Float64bits(rate))
+}
+
+// runLoadTest connects every simulated device and publishes from all of
+// them at once, ignoring the realistic interval, until the duration elapses
+// or the process is interrupted. Throughput is logged periodically and
+// summarised at the end.
+func runLoadTest(opts loadTestOptions) {
+	slog.Info("starting simulator load test",
+		"count", opts.count,
+		"host", opts.host,
+		"port", opts.port,
+		"rate", opts.rate,
+		"ramp_step", opts.rampStep,
+		"ramp_interval", opts.rampInterval,
+		"duration", opts.duration,
+	)
+
+	devices := connectLoadTestDevices(opts)
+	if len(devices) == 0 {
+		slog.Error("no simulator devices connected")
+		os.Exit(1)
+	}
+	defer func() {
+		for _, d := range devices {
+			d.client.Disconnect(250)
+		}
+	}()
+	slog.Info("load test devices connected", "connected", len(devices), "count", opts.count)
+
+	// Interrupts only end the test once it runs, so one during connecting
+	// still exits right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
+
+	lt := &loadTest{}
+	lt.setTargetRate(opts.rate)
+	var permits chan struct{}
+	if opts.rate > 0 {
+		permits = make(chan struct{}, len(devices))
+		go lt.pace(ctx, permits, opts.rampStep, opts.rampInterval)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loc := opts.locations[i%len(opts.locations)]
+			lt.publish(ctx, d, opts.region, opts.channel, loc[0], loc[1], permits)
+		}()
+	}
+	go lt.report(ctx)
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	published := lt.published.Load()
+	slog.Info("load test finished",
+		"duration", elapsed.Round(time.Millisecond).String(),
+		"published", published,
+		"failed", lt.failed.Load(),
+		"missed", lt.missed.Load(),
+		"rate", fmt.Sprintf("%.1f/s", float64(published)/elapsed.Seconds()),
+	)
+}
+
+// connectLoadTestDevices connects the simulated devices in parallel and
+// returns those that connected.
+func connectLoadTestDevices(opts loadTestOptions) []loadTestDevice {
+	var (
+		mu      sync.Mutex
+		devices []loadTestDevice
+		wg      sync.WaitGroup
+	)
+	// Bound concurrent connects so a large count does not overwhelm the
+	// broker before the test even starts.
+	sem := make(chan struct{}, 32)
+	for i := range opts.count {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			nodeNum := uint32(0xdeadbe00 + i)
+			id := nodeID(nodeNum)
+			client, err := connectSimDevice(id, opts.host, opts.port, opts.username, opts.password, false)
+			if err != nil {
+				slog.Warn("simulator device failed to connect", "id", id, "err", err)
+				return
+			}
+			mu.Lock()
+			devices = append(devices, loadTestDevice{nodeNum: nodeNum, client: client})
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return devices
+}
+
+// publish sends packets from one device until ctx is done, waiting for a
+// permit before each one when permits is not nil.
+func (lt *loadTest) publish(ctx context.Context, d loadTestDevice, region, channel string, baseLat, baseLon float64, permits <-chan struct{}) {
+	topic := fmt.Sprintf("msh/%s/2/json/%s/%s", region, channel, nodeID(d.nodeNum))
+	state := newSimState(d.nodeNum, baseLat, baseLon)
+	for tick := 0; ctx.Err() == nil; tick++ {
+		if permits != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-permits:
+			}
+		}
+		evolveSimState(&state)
+		_, data, err := simPacket(&state, tick)
+		if err != nil {
+			slog.Error("failed to marshal sim payload", "err", err)
+			return
+		}
+		tok := d.client.Publish(topic, 0, false, data)
+		tok.Wait()
+		if tok.Error() != nil {
+			lt.failed.Add(1)
+			continue
+		}
+		lt.published.Add(1)
+	}
+}
+
+// pace hands out permits at the target rate, raising it by step every
+// interval when step is positive. Permits the devices are too busy to take
+// are counted as missed rather than queued, so the achieved rate shows where
+// the devices or the broker stop keeping up.
+func (lt *loadTest) pace(ctx context.Context, permits chan<- struct{}, step float64, interval time.Duration) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	last, lastRamp := time.Now(), time.Now()
+	var due float64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if step > 0 && now.Sub(lastRamp) >= interval {
+				lt.setTargetRate(lt.targetRate() + step)
+				lastRamp = now
+				slog.Info("load test rate raised", "rate", lt.targetRate())
+			}
+			due += lt.targetRate() * now.Sub(last).Seconds()
+			last = now
+			for ; due >= 1; due-- {
+				select {
+				case permits <- struct{}{}:
+				default:
+					lt.missed.Add(1)
+				}
+			}
+		}
+	}
+}
+
+// report logs the throughput achieved over each report interval.
+func (lt *loadTest) report(ctx context.Context) {
+	ticker := time.NewTicker(loadTestReportInterval)
+	defer ticker.Stop()
+	var lastPublished, lastFailed, lastMissed uint64
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			published, failed, missed := lt.published.Load(), lt.failed.Load(), lt.missed.Load()
+			secs := now.Sub(last).Seconds()
+			attrs := []any{
+				"rate", fmt.Sprintf("%.1f/s", float64(published-lastPublished)/secs),
+				"failed", failed - lastFailed,
+				"missed", missed - lastMissed,
+				"published", published,
+			}
+			if target := lt.targetRate(); target > 0 {
+				attrs = append(attrs, "target", fmt.Sprintf("%.1f/s", target))
+			}
+			slog.Info("load test throughput", attrs...)
+			lastPublished, lastFailed, lastMissed, last = published, failed, missed, now
+		}
+	}
+}
@@ -48,6 +48,11 @@ func runSimulate(args []string) {
 	interval := fs.Duration("interval", 5*time.Second, "Publish interval per device")
 	region := fs.String("region", "EU_868", "Meshtastic region string")
 	channel := fs.String("channel", "LongFast", "Meshtastic channel name")
+	loadTest := fs.Bool("load-test", false, "Ignore -interval and publish as fast as possible, or at -rate, reporting throughput")
+	rate := fs.Float64("rate", 0, "Load test: total messages per second across all devices (0 is unlimited)")
+	rampStep := fs.Float64("ramp-step", 0, "Load test: raise -rate by this many messages per second every -ramp-interval")
+	rampInterval := fs.Duration("ramp-interval", 10*time.Second, "Load test: how often -rate is raised by -ramp-step")
+	duration := fs.Duration("duration", 0, "Load test: stop after this long (0 runs until interrupted)")
 
 	if err := fs.Parse(args); err != nil {
 		os.Exit(1)
@@ -59,6 +64,30 @@ func runSimulate(args []string) {
 		os.Exit(1)
 	}
 
+	if *loadTest {
+		opts := loadTestOptions{
+			host:         *host,
+			port:         *port,
+			username:     *username,
+			password:     *password,
+			count:        *count,
+			region:       *region,
+			channel:      *channel,
+			rate:         *rate,
+			rampStep:     *rampStep,
+			rampInterval: *rampInterval,
+			duration:     *duration,
+			locations:    ljubljanaLocations,
+		}
+		if err := opts.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			fs.Usage()
+			os.Exit(1)
+		}
+		runLoadTest(opts)
+		return
+	}
+
 	slog.Info("starting simulator",
 		"count", *count,
 		"host", *host,
@@ -85,12 +114,45 @@ func runSimulate(args []string) {
 }
 
 func runDevice(nodeNum uint32, host string, port int, username, password string, interval time.Duration, region, channel string, baseLat, baseLon float64) {
-	id := fmt.Sprintf("!%08x", nodeNum)
-	broker := fmt.Sprintf("tcp://%s:%d", host, port)
+	id := nodeID(nodeNum)
+	client, err := connectSimDevice(id, host, port, username, password, true)
+	if err != nil {
+		slog.Error("simulator device failed to connect", "id", id, "err", err)
+		return
+	}
+	defer client.Disconnect(250)
 
 	// Topic: msh/{region}/2/json/{channel}/{node_id}
-	topicBase := fmt.Sprintf("msh/%s/2/json/%s/%s", region, channel, id)
+	topic := fmt.Sprintf("msh/%s/2/json/%s/%s", region, channel, id)
+	state := newSimState(nodeNum, baseLat, baseLon)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	tick := 0
+	for range ticker.C {
+		evolveSimState(&state)
+		typ, data, err := simPacket(&state, tick)
+		tick++
+		if err != nil {
+			slog.Error("failed to marshal sim payload", "id", id, "err", err)
+			continue
+		}
+
+		tok := client.Publish(topic, 0, false, data)
+		tok.Wait()
+		if tok.Error() != nil {
+			slog.Warn("publish failed", "id", id, "err", tok.Error())
+		} else {
+			slog.Info("published", "id", id, "type", typ, "battery", state.battLevel)
+		}
+	}
+}
 
+// connectSimDevice connects a simulated device to the broker, logging
+// connection changes when verbose.
+func connectSimDevice(id, host string, port int, username, password string, verbose bool) (pahomqtt.Client, error) {
+	broker := fmt.Sprintf("tcp://%s:%d", host, port)
 	opts := pahomqtt.NewClientOptions().
 		AddBroker(broker).
 		SetClientID(id).
@@ -98,22 +160,25 @@ func runDevice(nodeNum uint32, host string, port int, username, password string,
 		SetPassword(password).
 		SetAutoReconnect(true).
 		SetConnectRetry(true).
-		SetConnectRetryInterval(5 * time.Second).
-		SetOnConnectHandler(func(_ pahomqtt.Client) {
+		SetConnectRetryInterval(5 * time.Second)
+	if verbose {
+		opts.SetOnConnectHandler(func(_ pahomqtt.Client) {
 			slog.Info("simulator device connected", "id", id)
-		}).
-		SetConnectionLostHandler(func(_ pahomqtt.Client, err error) {
+		})
+		opts.SetConnectionLostHandler(func(_ pahomqtt.Client, err error) {
 			slog.Warn("simulator device disconnected", "id", id, "err", err)
 		})
+	}
 
 	client := pahomqtt.NewClient(opts)
 	if tok := client.Connect(); tok.Wait() && tok.Error() != nil {
-		slog.Error("simulator device failed to connect", "id", id, "err", tok.Error())
-		return
+		return nil, tok.Error()
 	}
-	defer client.Disconnect(250)
+	return client, nil
+}
 
-	state := simState{
+func newSimState(nodeNum uint32, baseLat, baseLon float64) simState {
+	return simState{
 		nodeNum:    nodeNum,
 		latI:       int64(baseLat * 1e7),
 		lonI:       int64(baseLon * 1e7),
@@ -121,65 +186,41 @@ func runDevice(nodeNum uint32, host string, port int, username, password string,
 		satsInView: 8,
 		battLevel:  85.0,
 	}
+}
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	tick := 0
-	for range ticker.C {
-		evolveSimState(&state)
-
-		// Alternate between position and telemetry packets.
-		var topic string
-		var payloadObj any
-
-		if tick%2 == 0 {
-			topic = topicBase
-			payloadObj = map[string]any{
-				"from":      nodeNum,
-				"sender":    id,
-				"timestamp": time.Now().Unix(),
-				"type":      "position",
-				"payload": map[string]any{
-					"latitude_i":   state.latI,
-					"longitude_i":  state.lonI,
-					"altitude":     state.altitude,
-					"ground_speed": state.groundSpeed,
-					"sats_in_view": state.satsInView,
-				},
-			}
-		} else {
-			topic = topicBase
-			payloadObj = map[string]any{
-				"from":      nodeNum,
-				"sender":    id,
-				"timestamp": time.Now().Unix(),
-				"type":      "telemetry",
-				"payload": map[string]any{
-					"battery_level":       state.battLevel,
-					"voltage":             3.3 + state.battLevel/100.0,
-					"channel_utilization": 5.0,
-					"air_util_tx":         2.0,
-				},
-			}
-		}
-
-		data, err := json.Marshal(payloadObj)
-		if err != nil {
-			slog.Error("failed to marshal sim payload", "id", id, "err", err)
-			tick++
-			continue
-		}
-
-		tok := client.Publish(topic, 0, false, data)
-		tok.Wait()
-		if tok.Error() != nil {
-			slog.Warn("publish failed", "id", id, "err", tok.Error())
-		} else {
-			slog.Info("published", "id", id, "type", map[bool]string{true: "position", false: "telemetry"}[tick%2 == 0], "battery", state.battLevel)
-		}
-		tick++
+// simPacket returns the type and JSON encoding of the packet a device sends
+// on the given tick, alternating between position and telemetry packets.
+func simPacket(s *simState, tick int) (string, []byte, error) {
+	id := nodeID(s.nodeNum)
+	if tick%2 == 0 {
+		data, err := json.Marshal(map[string]any{
+			"from":      s.nodeNum,
+			"sender":    id,
+			"timestamp": time.Now().Unix(),
+			"type":      "position",
+			"payload": map[string]any{
+				"latitude_i":   s.latI,
+				"longitude_i":  s.lonI,
+				"altitude":     s.altitude,
+				"ground_speed": s.groundSpeed,
+				"sats_in_view": s.satsInView,
+			},
+		})
+		return "position", data, err
 	}
+	data, err := json.Marshal(map[string]any{
+		"from":      s.nodeNum,
+		"sender":    id,
+		"timestamp": time.Now().Unix(),
+		"type":      "telemetry",
+		"payload": map[string]any{
+			"battery_level":       s.battLevel,
+			"voltage":             3.3 + s.battLevel/100.0,
+			"channel_utilization": 5.0,
+			"air_util_tx":         2.0,
+		},
+	})
+	return "telemetry", data, err
 }
 
 // evolveSimState applies small realistic changes to simulate sensor variation.
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotWidth  = 800
+	snapshotHeight = 600
+	// snapshotCacheTTL is how long a rendered snapshot is served before it
+	// is rendered again.
+	snapshotCacheTTL = 30 * time.Second
+	// snapshotMaxBytes bounds the image accepted from a static map service.
+	snapshotMaxBytes  = 8 << 20
+	snapshotDotRadius = 5
+)
+
+var (
+	snapshotBackground = color.RGBA{0x1f, 0x29, 0x37, 0xff}
+	snapshotOnline     = color.RGBA{0x22, 0xc5, 0x5e, 0xff}
+	snapshotOffline    = color.RGBA{0x9c, 0xa3, 0xaf, 0xff}
+)
+
+type renderedSnapshot struct {
+	data        []byte
+	contentType string
+	expires     time.Time
+}
+
+// SnapshotRenderer renders a static image of device positions. With a
+// service URL it fetches the image from a static map service, otherwise (or
+// when the service fails) it draws the devices as dots on a plain
+// background. Rendered images are cached briefly per filter.
+type SnapshotRenderer struct {
+	serviceURL string
+	client     *http.Client
+
+	mu    sync.Mutex
+	cache map[string]renderedSnapshot
+}
+
+// NewSnapshotRenderer returns a renderer. serviceURL may contain the
+// placeholders {width}, {height} and {markers}, the last replaced with the
+// query-escaped device positions as lat,lon pairs separated by |.
+func NewSnapshotRenderer(serviceURL string) *SnapshotRenderer {
+	return &SnapshotRenderer{
+		serviceURL: serviceURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		cache:      make(map[string]renderedSnapshot),
+	}
+}
+
+// Render returns the image of the devices returned by list and its content
+// type, reusing the image cached under key if it has not expired. list is
+// only called when the image has to be rendered again.
+func (r *SnapshotRenderer) Render(ctx context.Context, key string, list func(context.Context) ([]DeviceView, error)) ([]byte, string, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if c, ok := r.cache[key]; ok && now.Before(c.expires) {
+		r.mu.Unlock()
+		return c.data, c.contentType, nil
+	}
+	r.mu.Unlock()
+
+	views, err := list(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var located []DeviceView
+	for _, v := range views {
+		if hasFix(v) {
+			located = append(located, v)
+		}
+	}
+
+	var data []byte
+	contentType := "image/png"
+	if r.serviceURL != "" && len(located) > 0 {
+		data, contentType, err = r.fetch(ctx, located)
+		if err != nil {
+			slog.Warn("static map service failed, drawing snapshot locally", "err", err)
+		}
+	}
+	if r.serviceURL == "" || len(located) == 0 || err != nil {
+		contentType = "image/png"
+		if data, err = drawSnapshot(located); err != nil {
+			return nil, "", err
+		}
+	}
+
+	r.mu.Lock()
+	for k, c := range r.cache {
+		if !now.Before(c.expires) {
+			delete(r.cache, k)
+		}
+	}
+	r.cache[key] = renderedSnapshot{data: data, contentType: contentType, expires: now.Add(snapshotCacheTTL)}
+	r.mu.Unlock()
+	return data, contentType, nil
+}
+
+// fetch requests the image from the static map service.
+func (r *SnapshotRenderer) fetch(ctx context.Context, views []DeviceView) ([]byte, string, error) {
+	markers := make([]string, 0, len(views))
+	for _, v := range views {
+		markers = append(markers, fmt.Sprintf("%.5f,%.5f", v.Lat, v.Lon))
+	}
+	target := strings.NewReplacer(
+		"{width}", strconv.Itoa(snapshotWidth),
+		"{height}", strconv.Itoa(snapshotHeight),
+		"{markers}", url.QueryEscape(strings.Join(markers, "|")),
+	).Replace(r.serviceURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("static map service returned %s", resp.Status)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("static map service returned %q, not an image", contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, snapshotMaxBytes))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentType, nil
+}
+
+// drawSnapshot draws each device as a dot, green when online and grey
+// otherwise, fitted to the bounding box of all devices in an equirectangular
+// projection.
+func drawSnapshot(views []DeviceView) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, snapshotWidth, snapshotHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(snapshotBackground), image.Point{}, draw.Src)
+
+	if len(views) > 0 {
+		minLat, maxLat := views[0].Lat, views[0].Lat
+		minLon, maxLon := views[0].Lon, views[0].Lon
+		for _, v := range views[1:] {
+			minLat, maxLat = min(minLat, v.Lat), max(maxLat, v.Lat)
+			minLon, maxLon = min(minLon, v.Lon), max(maxLon, v.Lon)
+		}
+		// Shrink longitudes by the cosine of the central latitude so
+		// distances look roughly the same in both directions.
+		scaleX := math.Cos((minLat + maxLat) / 2 * math.Pi / 180)
+		spanX := max((maxLon-minLon)*scaleX, 1e-3)
+		spanY := max(maxLat-minLat, 1e-3)
+		margin := float64(4 * snapshotDotRadius)
+		scale := min((snapshotWidth-2*margin)/spanX, (snapshotHeight-2*margin)/spanY)
+		midLon, midLat := (minLon+maxLon)/2, (minLat+maxLat)/2
+
+		for _, v := range views {
+			x := snapshotWidth/2 + (v.Lon-midLon)*scaleX*scale
+			y := snapshotHeight/2 - (v.Lat-midLat)*scale
+			c := snapshotOffline
+			if v.Online {
+				c = snapshotOnline
+			}
+			drawDot(img, int(x), int(y), c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawDot(img *image.RGBA, cx, cy int, c color.RGBA) {
+	r := snapshotDotRadius
+	for y := -r; y <= r; y++ {
+		for x := -r; x <= r; x++ {
+			if x*x+y*y <= r*r {
+				img.SetRGBA(cx+x, cy+y, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// SocketFeed publishes the device list to local processes connected to a
+// Unix domain socket, as one JSON DeviceMessage per line. Each reader gets
+// the current list on connect and then every broadcast. A slow reader only
+// ever has the latest list queued, since each message supersedes the last.
+type SocketFeed struct {
+	path     string
+	listener net.Listener
+	snapshot func(context.Context) ([]byte, error)
+
+	mu      sync.RWMutex
+	readers map[*socketReader]struct{}
+}
+
+// socketReader is a connected local reader with a single pending message.
+type socketReader struct {
+	conn   net.Conn
+	notify chan struct{}
+
+	mu      sync.Mutex
+	pending []byte
+}
+
+// NewSocketFeed listens on path, replacing a socket left behind by a previous
+// run. snapshot returns the line sent to new readers.
+func NewSocketFeed(path string, snapshot func(context.Context) ([]byte, error)) (*SocketFeed, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, errors.New(path + " exists and is not a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &SocketFeed{
+		path:     path,
+		listener: ln,
+		snapshot: snapshot,
+		readers:  make(map[*socketReader]struct{}),
+	}, nil
+}
+
+// Start accepts readers until ctx is cancelled, then disconnects them and
+// removes the socket. The returned channel is closed once that is done.
+func (f *SocketFeed) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		// Closing a Unix listener also removes its socket file.
+		_ = f.listener.Close()
+	}()
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for {
+			conn, err := f.listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("device socket accept failed", "path", f.path, "err", err)
+				}
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f.serve(ctx, conn)
+			}()
+		}
+		wg.Wait()
+	}()
+	return done
+}
+
+// Count returns the number of connected readers.
+func (f *SocketFeed) Count() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.readers)
+}
+
+// Publish queues line for every connected reader, replacing any message
+// still pending.
+func (f *SocketFeed) Publish(line []byte) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for r := range f.readers {
+		r.enqueue(line)
+	}
+}
+
+func (f *SocketFeed) serve(ctx context.Context, conn net.Conn) {
+	r := &socketReader{conn: conn, notify: make(chan struct{}, 1)}
+	// Register before loading the snapshot so no broadcast in between is
+	// missed; a newer broadcast replaces the snapshot if it is still queued.
+	f.mu.Lock()
+	f.readers[r] = struct{}{}
+	f.mu.Unlock()
+	slog.Info("device socket reader connected", "readers", f.Count())
+	defer func() {
+		f.mu.Lock()
+		delete(f.readers, r)
+		f.mu.Unlock()
+		_ = conn.Close()
+		slog.Info("device socket reader disconnected", "readers", f.Count())
+	}()
+
+	snapCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	line, err := f.snapshot(snapCtx)
+	cancel()
+	if err != nil {
+		slog.Error("failed to load device socket snapshot", "err", err)
+		return
+	}
+	r.mu.Lock()
+	if r.pending == nil {
+		r.pending = line
+	}
+	r.mu.Unlock()
+	r.signal()
+
+	// Readers are not expected to send anything; reading only notices
+	// when they hang up.
+	closed := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case <-r.notify:
+		}
+		r.mu.Lock()
+		line := r.pending
+		r.pending = nil
+		r.mu.Unlock()
+		if line == nil {
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(line); err != nil {
+			slog.Warn("device socket write failed", "err", err)
+			return
+		}
+	}
+}
+
+func (r *socketReader) enqueue(line []byte) {
+	r.mu.Lock()
+	r.pending = line
+	r.mu.Unlock()
+	r.signal()
+}
+
+func (r *socketReader) signal() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// socketLine returns the JSON encoding of msg followed by a newline.
+func socketLine(msg *wsMessage) []byte {
+	line := make([]byte, 0, len(msg.json)+1)
+	line = append(line, msg.json...)
+	return append(line, '\n')
+}
@@ -2,31 +2,53 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jarv/mqtt/db"
+	"golang.org/x/sync/semaphore"
 )
 
 // MeshtasticPacket is the top-level JSON envelope published by Meshtastic nodes.
+//
+// Sender is the ID of the gateway that uplinked the packet, not of the node
+// that sent it, so it differs from From for every packet the gateway heard
+// over the mesh. From alone identifies the device.
 type MeshtasticPacket struct {
 	From      uint32          `json:"from"`
+	To        uint32          `json:"to"`
 	Sender    string          `json:"sender"`
 	Timestamp int64           `json:"timestamp"`
 	Type      string          `json:"type"`
 	Payload   json.RawMessage `json:"payload"`
+
+	// Reception metadata added by the gateway that uplinked the packet,
+	// not by the originating node.
+	RSSI     float64 `json:"rssi"`
+	SNR      float64 `json:"snr"`
+	HopsAway int64   `json:"hops_away"`
 }
 
 // PositionPayload is the payload for type=position packets.
 type PositionPayload struct {
-	LatitudeI   int64   `json:"latitude_i"`
-	LongitudeI  int64   `json:"longitude_i"`
-	Altitude    float64 `json:"altitude"`
-	GroundSpeed float64 `json:"ground_speed"`
-	SatsInView  int64   `json:"sats_in_view"`
+	LatitudeI  int64 `json:"latitude_i"`
+	LongitudeI int64 `json:"longitude_i"`
+	// Altitude is nil when the packet omits it, so the stored altitude is
+	// kept instead of being reset to 0.
+	Altitude    *float64 `json:"altitude"`
+	GroundSpeed float64  `json:"ground_speed"`
+	// GroundTrack is the reported course in 1e-5 degrees; zero when the
+	// firmware omits it.
+	GroundTrack int64 `json:"ground_track"`
+	SatsInView  int64 `json:"sats_in_view"`
 }
 
 // TelemetryPayload is the payload for type=telemetry packets.
@@ -35,12 +57,35 @@ type TelemetryPayload struct {
 	Voltage      float64 `json:"voltage"`
 	ChannelUtil  float64 `json:"channel_utilization"`
 	AirUtilTX    float64 `json:"air_util_tx"`
+
+	// Environment sensor readings; nil when not reported.
+	Temperature        *float64 `json:"temperature"`
+	RelativeHumidity   *float64 `json:"relative_humidity"`
+	BarometricPressure *float64 `json:"barometric_pressure"`
 }
 
 // DeviceMessage is sent over WebSocket to browsers.
 type DeviceMessage struct {
 	Type string       `json:"type"`
 	Data []DeviceView `json:"data"`
+	// Truncated is set on a snapshot cut to SnapshotLimit devices; Total
+	// is then the number of devices that matched.
+	Truncated bool `json:"truncated,omitempty"`
+	Total     int  `json:"total,omitempty"`
+}
+
+// DeviceDeltaMessage carries a single changed device to clients that
+// negotiated the delta capability.
+type DeviceDeltaMessage struct {
+	Type string     `json:"type"`
+	Data DeviceView `json:"data"`
+}
+
+// DeviceRemovedMessage tells delta clients to drop a device that no longer
+// matches their filter.
+type DeviceRemovedMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
 }
 
 // DeviceView is the browser-facing representation of a device.
@@ -50,10 +95,47 @@ type DeviceView struct {
 	Lon          float64   `json:"lon"`
 	Alt          float64   `json:"alt"`
 	Speed        float64   `json:"speed"`
+	Course       float64   `json:"course"`
 	Sats         int64     `json:"sats"`
 	BatteryLevel int64     `json:"battery_level"`
+	Voltage      float64   `json:"voltage"`
 	Online       bool      `json:"online"`
 	LastSeen     time.Time `json:"last_seen"`
+	Channel      string    `json:"channel"`
+	RTCUnset     bool      `json:"rtc_unset"`
+	Tags         []string  `json:"tags"`
+	LongName     string    `json:"long_name"`
+	ShortName    string    `json:"short_name"`
+	// DisplayName is the short name, disambiguated when several devices
+	// share it.
+	DisplayName string `json:"display_name"`
+	// Best reception among the gateways that recently heard the device,
+	// or the last reception stored for it when none did.
+	RSSI     float64 `json:"rssi"`
+	SNR      float64 `json:"snr"`
+	Gateway  string  `json:"gateway"`
+	HopsAway int64   `json:"hops_away"`
+	// GatewayCount is how many gateways recently heard the device.
+	GatewayCount int `json:"gateway_count"`
+	// Override is set when the position was pinned by an operator and
+	// reported positions are ignored.
+	Override bool `json:"override"`
+	// PositionSource is the packet type the stored position came from.
+	PositionSource string `json:"position_source"`
+	// CourseSource tells whether Course was reported by the device or
+	// computed from its last two fixes; empty when unknown.
+	CourseSource string `json:"course_source"`
+	// Owner, Team and Notes come from the enrichment webhook; empty when
+	// it is not configured or has not answered for the device.
+	Owner string `json:"owner"`
+	Team  string `json:"team"`
+	Notes string `json:"notes"`
+	// Reliability is the percentage of the device's routing packets in the
+	// last 24 hours that acknowledged a delivery, nil without any.
+	Reliability *float64 `json:"reliability"`
+	// Role is the Meshtastic role from the last nodeinfo that reported one.
+	Role  string      `json:"role"`
+	Class DeviceClass `json:"class"`
 }
 
 // nodeID returns the canonical hex node ID string for a uint32 node number.
@@ -61,125 +143,597 @@ func nodeID(from uint32) string {
 	return fmt.Sprintf("!%08x", from)
 }
 
+// validNodeID reports whether id is a canonical node ID as returned by
+// nodeID.
+func validNodeID(id string) bool {
+	hex, ok := strings.CutPrefix(id, "!")
+	return ok && len(hex) == 8 && strings.Trim(hex, "0123456789abcdef") == ""
+}
+
+// broadcastNodeNum is the destination of packets addressed to every node. It
+// never identifies a real node.
+const broadcastNodeNum = 0xffffffff
+
+// validNodeNum reports whether n can identify a real node: node numbers zero
+// and the broadcast address cannot. Negative or out-of-range numbers already
+// fail to unmarshal into a uint32.
+func validNodeNum(n uint32) bool {
+	return n != 0 && n != broadcastNodeNum
+}
+
+// minPlausibleTimestamp is the earliest packet timestamp (2020-01-01 UTC)
+// treated as coming from a node with a set clock.
+const minPlausibleTimestamp = 1577836800
+
+// TimestampPolicy controls how packets with an unset or implausible
+// timestamp are handled.
+type TimestampPolicy string
+
+const (
+	// TimestampServer accepts the packet, uses the server receive time and
+	// flags the device as having an unset RTC.
+	TimestampServer TimestampPolicy = "server"
+	// TimestampDrop discards the packet.
+	TimestampDrop TimestampPolicy = "drop"
+)
+
+// SubscriberOptions configures packet handling.
+type SubscriberOptions struct {
+	TimestampPolicy TimestampPolicy
+	// ParseErrorWindow is how often repeated parse errors on a topic are
+	// summarised in the log.
+	ParseErrorWindow time.Duration
+	// DeviceErrors keeps the last parse or validation error of each device
+	// in memory for DeviceStatus.
+	DeviceErrors bool
+	// HistoryRetention is how long history entries are kept. Zero keeps
+	// them forever.
+	HistoryRetention time.Duration
+	// DBConcurrency limits how many handlers write to the database at once;
+	// the rest wait their turn. Zero or less means no limit.
+	DBConcurrency int64
+	// HoldNewDevices keeps devices reporting their first fix out of
+	// snapshots and update hooks until HoldFixes consecutive fixes fall
+	// within HoldRadius meters of each other.
+	HoldNewDevices bool
+	HoldFixes      int
+	HoldRadius     float64
+	// RawPackets stores every received message verbatim. Cleanup keeps
+	// the newest RawPacketsMaxBytes of payload; zero keeps everything.
+	RawPackets         bool
+	RawPacketsMaxBytes int64
+	// MessagesMax is the number of text messages cleanup keeps; zero keeps
+	// them all.
+	MessagesMax int64
+	// DisambiguateNames suffixes display names of devices sharing a short
+	// name with part of their node ID.
+	DisambiguateNames bool
+	// PublicPrecision snaps positions served to clients to a grid of about
+	// this many meters. Stored positions stay exact. Zero disables it.
+	PublicPrecision float64
+	// MinSats rejects fixes reporting fewer satellites in view. Fixes
+	// without a satellite count are accepted. Zero disables the check.
+	MinSats int64
+	// AltitudeUnit is the unit reported altitudes are read in.
+	AltitudeUnit AltitudeUnit
+	// MaxSpeedKmh rejects fixes implying a faster move since the previous
+	// fix. Zero disables the check.
+	MaxSpeedKmh float64
+	// PositionSources ranks the packet types that carry positions, highest
+	// priority first. A position from a lower-ranked source is ignored
+	// within PositionSourceWindow of one from a higher-ranked source. A
+	// zero window accepts every source.
+	PositionSources      []string
+	PositionSourceWindow time.Duration
+	// BroadcastWindow delays the broadcast of a device update received
+	// over MQTT by this long, so further updates of the same device in the
+	// meantime, such as a position followed by telemetry, go out as one.
+	// Zero broadcasts every update immediately.
+	BroadcastWindow time.Duration
+	// BroadcastMaxRate caps broadcasts to WebSocket clients at this many
+	// per second in total, coalescing all changes in between into each
+	// one. Zero broadcasts every change as soon as the previous broadcast
+	// is sent.
+	BroadcastMaxRate float64
+	// OfflineAfter marks devices silent for longer offline, at startup and
+	// on every cleanup. Zero leaves the online flag as last reported.
+	OfflineAfter time.Duration
+	// StaleAfter removes devices silent for this long on every cleanup.
+	// Zero keeps them.
+	StaleAfter time.Duration
+	// SnapshotLimit caps the snapshots sent to a single WebSocket client,
+	// on connect and on request, to the most recently seen devices.
+	// Broadcast snapshots are not capped. Zero disables it.
+	SnapshotLimit int
+	// CourseMinMove is how far in meters a device without a reported
+	// course must move before its course is computed from consecutive
+	// fixes. Zero only uses reported courses.
+	CourseMinMove float64
+	// WarmupTimeout suppresses broadcasts of device updates received over
+	// MQTT after startup until no packet arrived for WarmupQuiet, or at
+	// most this long, and then sends a single snapshot. Zero disables it.
+	WarmupTimeout time.Duration
+	// WarmupQuiet is the gap between packets that ends the warmup early.
+	WarmupQuiet time.Duration
+	// PacketTypes lists the packet types to process; others are ignored.
+	// Nil processes every known type.
+	PacketTypes []string
+	// DeviceClassRoles maps Meshtastic roles to the device class they
+	// imply; see classify.
+	DeviceClassRoles map[string]DeviceClass
+}
+
+// packetInfo carries the envelope fields shared by all packet handlers.
+type packetInfo struct {
+	topic    string
+	id       string
+	channel  string
+	rtcUnset bool
+	// rssi and snr are the envelope's reception metadata, zero when the
+	// gateway did not add any.
+	rssi, snr float64
+}
+
+// signal returns the packet's RSSI and SNR, or those stored for the device
+// when the packet carries no reception metadata.
+func (info packetInfo) signal(existing db.Device) (rssi, snr float64) {
+	if info.rssi == 0 && info.snr == 0 {
+		return existing.Rssi, existing.Snr
+	}
+	return info.rssi, info.snr
+}
+
 // Subscriber handles incoming MQTT messages and persists them.
 type Subscriber struct {
+	sqlDB   *sql.DB
 	queries *db.Queries
 	cm      *ConnectionManager
+	opts    SubscriberOptions
+
+	parseErrors *parseErrorTracker
+	packetTypes *packetTypeFilter
+	dbSem       *semaphore.Weighted
+	pending     *pendingTracker
+	devices     *deviceLocks
+	warmup      *warmup
+	dirty       *dirtyDevices
+	socket      *SocketFeed
+
+	// deviceErrors is nil when DeviceErrors is off.
+	deviceErrors *deviceErrorTracker
+
+	onUpdate    []func(DeviceView)
+	onTelemetry []func(string, TelemetryPayload)
+	onRemove    []func(ids []string)
+
+	coalesceMu sync.Mutex
+	// coalescing holds the devices waiting for their broadcast and the
+	// channels they were on before the updates being coalesced.
+	coalescing map[string][]string
+}
+
+func NewSubscriber(sqlDB *sql.DB, cm *ConnectionManager, opts SubscriberOptions) *Subscriber {
+	s := &Subscriber{
+		sqlDB:       sqlDB,
+		queries:     db.New(sqlDB),
+		cm:          cm,
+		opts:        opts,
+		parseErrors: newParseErrorTracker(opts.ParseErrorWindow),
+		packetTypes: newPacketTypeFilter(opts.PacketTypes),
+		devices:     newDeviceLocks(),
+		dirty:       newDirtyDevices(),
+		coalescing:  make(map[string][]string),
+	}
+	if opts.DBConcurrency > 0 {
+		s.dbSem = semaphore.NewWeighted(opts.DBConcurrency)
+	}
+	if opts.HoldNewDevices {
+		s.pending = newPendingTracker(opts.HoldFixes, opts.HoldRadius)
+	}
+	if opts.DeviceErrors {
+		s.deviceErrors = newDeviceErrorTracker()
+	}
+	if opts.WarmupTimeout > 0 {
+		s.warmup = newWarmup(opts.WarmupQuiet, opts.WarmupTimeout, s.endWarmup)
+	}
+	return s
+}
+
+// Ping checks that the database answers queries.
+func (s *Subscriber) Ping(ctx context.Context) error {
+	_, err := s.queries.Ping(ctx)
+	return err
+}
+
+// CountDevices returns the number of stored devices.
+func (s *Subscriber) CountDevices(ctx context.Context) (int64, error) {
+	return s.queries.CountDevices(ctx)
+}
+
+// SetSocketFeed makes broadcasts also go to the readers of feed. It must be
+// called before messages are handled.
+func (s *Subscriber) SetSocketFeed(feed *SocketFeed) {
+	s.socket = feed
+}
+
+// SocketSnapshot returns the full device list as a line for the device
+// socket.
+func (s *Subscriber) SocketSnapshot(ctx context.Context) ([]byte, error) {
+	views, err := s.ListViews(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := marshalDevices(views)
+	if err != nil {
+		return nil, err
+	}
+	return socketLine(msg), nil
+}
+
+// endWarmup sends the snapshot of everything received during warm-up.
+func (s *Subscriber) endWarmup() {
+	s.broadcastDevices(nil)
+}
+
+// acquireDB waits for a database slot and returns the function that
+// releases it. The function may be called more than once, so callers can
+// release the slot right after their last statement and still defer it.
+func (s *Subscriber) acquireDB(ctx context.Context) (func(), error) {
+	if s.dbSem == nil {
+		return func() {}, nil
+	}
+	if err := s.dbSem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { s.dbSem.Release(1) }) }, nil
 }
 
-func NewSubscriber(queries *db.Queries, cm *ConnectionManager) *Subscriber {
-	return &Subscriber{queries: queries, cm: cm}
+// OnRemove registers fn to be called with the IDs of the devices cleanup
+// removed as stale, so hooks can forget them. It must be called before
+// cleanup starts.
+func (s *Subscriber) OnRemove(fn func(ids []string)) {
+	s.onRemove = append(s.onRemove, fn)
+}
+
+// inTx runs fn with queries in a transaction, committing it if fn succeeds.
+func (s *Subscriber) inTx(ctx context.Context, fn func(*db.Queries) error) error {
+	tx, err := s.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(s.queries.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// OnUpdate registers fn to be called with the new view, as View builds it,
+// after every device update. It must be called before the broker starts
+// delivering messages.
+func (s *Subscriber) OnUpdate(fn func(DeviceView)) {
+	s.onUpdate = append(s.onUpdate, fn)
+}
+
+// OnTelemetry registers fn to be called with every parsed telemetry payload,
+// including environment telemetry that does not update the device row.
+func (s *Subscriber) OnTelemetry(fn func(id string, t TelemetryPayload)) {
+	s.onTelemetry = append(s.onTelemetry, fn)
+}
+
+// notifyUpdate passes the view of device, built like View, to the hooks.
+// release is called once the view is loaded, so the hooks do not hold up
+// database work.
+func (s *Subscriber) notifyUpdate(ctx context.Context, device db.Device, release func()) {
+	if len(s.onUpdate) == 0 {
+		release()
+		return
+	}
+	v, err := s.deviceView(ctx, device)
+	release()
+	if err != nil {
+		slog.Error("failed to load device view for hooks", "id", device.ID, "err", err)
+		return
+	}
+	s.notifyHooks(v)
+}
+
+func (s *Subscriber) notifyHooks(v DeviceView) {
+	for _, fn := range s.onUpdate {
+		fn(v)
+	}
 }
 
 // HandleMessage is called by the broker on every published message.
 func (s *Subscriber) HandleMessage(topic string, payload []byte) {
+	messagesReceived.Inc()
 	// Only process JSON topics: msh/{region}/2/json/{channel}/{node}
 	if !isMeshtasticJSONTopic(topic) {
 		return
 	}
+	if s.warmup != nil {
+		s.warmup.packet()
+	}
+
+	if s.opts.RawPackets {
+		s.recordRawPacket(topic, payload)
+	}
 
 	var pkt MeshtasticPacket
 	if err := json.Unmarshal(payload, &pkt); err != nil {
-		slog.Warn("failed to parse meshtastic packet", "topic", topic, "err", err)
+		s.parseErrors.Record(topic, "meshtastic packet", err)
 		return
 	}
+	if !validNodeNum(pkt.From) {
+		s.parseErrors.Record(topic, "meshtastic packet", fmt.Errorf("invalid from node number %#x", pkt.From))
+		return
+	}
+
+	countPacket(pkt.Type)
+
+	info := packetInfo{
+		topic:   topic,
+		id:      nodeID(pkt.From),
+		channel: topicChannel(topic),
+		rssi:    pkt.RSSI,
+		snr:     pkt.SNR,
+	}
 
-	id := nodeID(pkt.From)
+	if !plausibleTimestamp(pkt.Timestamp, time.Now()) {
+		if s.opts.TimestampPolicy == TimestampDrop {
+			slog.Debug("dropping packet with implausible timestamp", "id", info.id, "timestamp", pkt.Timestamp)
+			s.deviceError(info.id, "packet", fmt.Errorf("implausible timestamp %d", pkt.Timestamp))
+			return
+		}
+		info.rtcUnset = true
+	}
 
+	s.recordGatewaySample(info, pkt)
+
+	if !s.packetTypes.accept(pkt.Type) {
+		return
+	}
 	switch pkt.Type {
 	case "position":
-		s.handlePosition(id, pkt.Payload)
+		s.handlePosition(info, pkt.Payload)
 	case "telemetry":
-		s.handleTelemetry(id, pkt.Payload)
-	default:
-		// ignore other packet types (nodeinfo, text, etc.)
-		return
+		s.handleTelemetry(info, pkt.Payload)
+	case "nodeinfo":
+		s.handleNodeInfo(info, pkt.Payload)
+	case "mapreport":
+		s.handleMapReport(info, pkt.Payload)
+	case "traceroute":
+		s.handleTraceroute(info, pkt.To, pkt.Payload)
+	case "routing":
+		s.handleRouting(info, pkt.Payload)
+	case "text":
+		s.handleText(info, pkt.Payload)
 	}
 }
 
-func (s *Subscriber) handlePosition(id string, raw json.RawMessage) {
+func (s *Subscriber) handlePosition(info packetInfo, raw json.RawMessage) {
 	var p PositionPayload
 	if err := json.Unmarshal(raw, &p); err != nil {
-		slog.Warn("failed to parse position payload", "id", id, "err", err)
+		s.payloadError(info, "position payload", err)
 		return
 	}
+	s.updatePosition(info, p, PositionSourcePosition)
+}
 
+// updatePosition stores a fix reported by source, subject to the fix,
+// range, satellite, override, source priority and speed checks.
+func (s *Subscriber) updatePosition(info packetInfo, p PositionPayload, source string) {
+	id := info.id
 	if p.LatitudeI == 0 && p.LongitudeI == 0 {
 		slog.Debug("ignoring position with no GPS fix", "id", id)
 		return
 	}
 
+	if p.SatsInView > 0 && p.SatsInView < s.opts.MinSats {
+		slog.Debug("ignoring position with too few satellites", "id", id, "sats", p.SatsInView, "min", s.opts.MinSats)
+		return
+	}
+
 	lat := float64(p.LatitudeI) * 1e-7
 	lon := float64(p.LongitudeI) * 1e-7
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		slog.Warn("dropping position out of range", "id", id, "lat", lat, "lon", lon)
+		s.deviceError(id, "position", fmt.Errorf("coordinates %g, %g out of range", lat, lon))
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	unlock := s.devices.lock(id)
+	defer unlock()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "id", id, "err", err)
+		return
+	}
+	defer release()
+
 	// Fetch existing device to preserve telemetry fields.
 	existing, err := s.queries.GetDevice(ctx, id)
 	var batteryLevel int64
+	var batteryVoltage, alt float64
 	if err == nil {
 		batteryLevel = existing.BatteryMv
+		batteryVoltage = existing.BatteryVoltage
+		alt = existing.Alt
+	}
+	if p.Altitude != nil {
+		alt = normalizeAltitude(*p.Altitude, s.opts.AltitudeUnit)
 	}
 
-	_, err = s.queries.UpsertDevice(ctx, db.UpsertDeviceParams{
-		ID:        id,
-		Lat:       lat,
-		Lon:       lon,
-		Alt:       p.Altitude,
-		Speed:     p.GroundSpeed,
-		Course:    0,
-		Sats:      p.SatsInView,
-		Hdop:      0,
-		BatteryMv: batteryLevel,
-		Rssi:      0,
-		Snr:       0,
-		Online:    1,
+	now := time.Now().UTC()
+	if err == nil && existing.PositionOverride != 0 {
+		slog.Debug("ignoring reported position for overridden device", "id", id, "lat", lat, "lon", lon)
+		return
+	}
+	if err == nil && s.outranked(existing, source, now) {
+		slog.Debug("ignoring position from lower-priority source", "id", id, "source", source, "current", existing.PositionSource)
+		return
+	}
+	// Holds start with a device's first fix, so devices that never report
+	// a position are not hidden. A failed lookup does not start one.
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && existing.Lat == 0 && existing.Lon == 0) {
+		s.holdNew(id)
+	}
+	pending := s.pending != nil && s.pending.observe(id, lat, lon)
+	// A held device's previous fix is not trusted yet, so it cannot be used
+	// to reject this one.
+	if err == nil && !pending && s.isGlitch(existing, lat, lon, now) {
+		return
+	}
+	var prev *db.Device
+	if err == nil {
+		prev = &existing
+	}
+	course, courseSource := courseFor(p, prev, lat, lon, s.opts.CourseMinMove)
+	rssi, snr := info.signal(existing)
+
+	device, err := s.queries.UpsertDevice(ctx, db.UpsertDeviceParams{
+		ID:             id,
+		Lat:            lat,
+		Lon:            lon,
+		Alt:            alt,
+		Speed:          p.GroundSpeed,
+		Course:         course,
+		Sats:           p.SatsInView,
+		Hdop:           0,
+		BatteryMv:      batteryLevel,
+		Rssi:           rssi,
+		Snr:            snr,
+		Online:         1,
+		Channel:        info.channel,
+		RtcUnset:       boolToInt(info.rtcUnset),
+		PositionAt:     now,
+		PositionSource: source,
+		CourseSource:   courseSource,
+		BatteryVoltage: batteryVoltage,
 	})
 	if err != nil {
 		slog.Error("failed to upsert device position", "id", id, "err", err)
 		return
 	}
+	// The devices row only holds the latest fix; every accepted fix is also
+	// appended to the position history.
+	err = s.queries.InsertPosition(ctx, db.InsertPositionParams{
+		DeviceID: id,
+		Lat:      device.Lat,
+		Lon:      device.Lon,
+		Alt:      device.Alt,
+		Speed:    device.Speed,
+	})
+	if err != nil {
+		slog.Error("failed to record position history", "id", id, "err", err)
+	}
+
+	slog.Info("position updated", "id", id, "lat", lat, "lon", lon, "sats", p.SatsInView, "source", source)
+	if pending {
+		return
+	}
+	s.notifyUpdate(ctx, device, release)
+	s.broadcastDevice(device, existing.Channel)
+}
+
+// holdNew starts holding a device reporting its first fix, if enabled.
+func (s *Subscriber) holdNew(id string) {
+	if s.pending != nil {
+		s.pending.hold(id)
+	}
+}
 
-	slog.Info("position updated", "id", id, "lat", lat, "lon", lon, "sats", p.SatsInView)
-	s.broadcastDevices(ctx)
+// isPending reports whether a device is held back from snapshots and hooks.
+func (s *Subscriber) isPending(id string) bool {
+	return s.pending != nil && s.pending.isPending(id)
 }
 
-func (s *Subscriber) handleTelemetry(id string, raw json.RawMessage) {
+// isGlitch reports whether moving from the device's previous fix to lat/lon
+// by now implies a speed above MaxSpeedKmh. A device without a previous fix
+// is always accepted.
+func (s *Subscriber) isGlitch(prev db.Device, lat, lon float64, now time.Time) bool {
+	if s.opts.MaxSpeedKmh <= 0 || (prev.Lat == 0 && prev.Lon == 0) {
+		return false
+	}
+	meters := haversineMeters(prev.Lat, prev.Lon, lat, lon)
+	// Guard against fixes arriving within the same second.
+	seconds := max(now.Sub(prev.PositionAt).Seconds(), 1)
+	kmh := meters / seconds * 3.6
+	if kmh > s.opts.MaxSpeedKmh {
+		slog.Debug("rejecting implausible position jump", "id", prev.ID, "meters", meters, "seconds", seconds, "kmh", kmh)
+		s.deviceError(prev.ID, "position", fmt.Errorf("implausible jump of %.0f m at %.0f km/h", meters, kmh))
+		return true
+	}
+	return false
+}
+
+func (s *Subscriber) handleTelemetry(info packetInfo, raw json.RawMessage) {
+	id := info.id
 	var t TelemetryPayload
 	if err := json.Unmarshal(raw, &t); err != nil {
-		slog.Warn("failed to parse telemetry payload", "id", id, "err", err)
+		s.payloadError(info, "telemetry payload", err)
 		return
 	}
 
-	if t.BatteryLevel == 0 && t.Voltage == 0 {
-		// not device telemetry (could be env sensor telemetry — ignore)
-		return
+	for _, fn := range s.onTelemetry {
+		fn(id, t)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	unlock := s.devices.lock(id)
+	defer unlock()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "id", id, "err", err)
+		return
+	}
+	defer release()
+
+	s.recordTelemetry(ctx, id, t)
+	if t.environment() {
+		s.markEnvSensor(ctx, id)
+	}
+
+	if t.BatteryLevel == 0 && t.Voltage == 0 {
+		// not device telemetry (env sensor telemetry is only kept in history)
+		return
+	}
+
 	// Fetch existing device to preserve position fields.
 	existing, err := s.queries.GetDevice(ctx, id)
 	if err != nil {
 		// Device not seen yet — create a placeholder with no location.
 		slog.Debug("telemetry for unknown device, creating placeholder", "id", id)
 	}
+	rssi, snr := info.signal(existing)
 
-	_, err = s.queries.UpsertDevice(ctx, db.UpsertDeviceParams{
-		ID:        id,
-		Lat:       existing.Lat,
-		Lon:       existing.Lon,
-		Alt:       existing.Alt,
-		Speed:     existing.Speed,
-		Course:    0,
-		Sats:      existing.Sats,
-		Hdop:      0,
-		BatteryMv: int64(t.BatteryLevel),
-		Rssi:      0,
-		Snr:       0,
-		Online:    1,
+	device, err := s.queries.UpsertDevice(ctx, db.UpsertDeviceParams{
+		ID:             id,
+		Lat:            existing.Lat,
+		Lon:            existing.Lon,
+		Alt:            existing.Alt,
+		Speed:          existing.Speed,
+		Course:         existing.Course,
+		Sats:           existing.Sats,
+		Hdop:           0,
+		BatteryMv:      int64(t.BatteryLevel),
+		Rssi:           rssi,
+		Snr:            snr,
+		Online:         1,
+		Channel:        info.channel,
+		RtcUnset:       boolToInt(info.rtcUnset),
+		PositionAt:     existing.PositionAt,
+		PositionSource: existing.PositionSource,
+		CourseSource:   existing.CourseSource,
+		BatteryVoltage: t.Voltage,
 	})
 	if err != nil {
 		slog.Error("failed to upsert device telemetry", "id", id, "err", err)
@@ -187,70 +741,451 @@ func (s *Subscriber) handleTelemetry(id string, raw json.RawMessage) {
 	}
 
 	slog.Info("telemetry updated", "id", id, "battery_level", t.BatteryLevel, "voltage", t.Voltage)
-	s.broadcastDevices(ctx)
+	if s.isPending(id) {
+		return
+	}
+	s.notifyUpdate(ctx, device, release)
+	s.broadcastDevice(device, existing.Channel)
 }
 
-// broadcastDevices sends the full device list to all WebSocket clients.
-func (s *Subscriber) broadcastDevices(ctx context.Context) {
-	devices, err := s.queries.ListDevices(ctx)
+// SetPositionOverride pins a device to a fixed position. Reported positions
+// are ignored until the override is cleared. It returns sql.ErrNoRows for an
+// unknown device.
+func (s *Subscriber) SetPositionOverride(ctx context.Context, id string, lat, lon, alt float64) (DeviceView, error) {
+	unlock := s.devices.lock(id)
+	defer unlock()
+
+	release, err := s.acquireDB(ctx)
 	if err != nil {
-		slog.Error("failed to list devices", "err", err)
-		return
+		return DeviceView{}, err
 	}
+	defer release()
 
-	views := make([]DeviceView, 0, len(devices))
-	for _, d := range devices {
-		views = append(views, deviceToView(d))
+	device, err := s.queries.SetDevicePosition(ctx, db.SetDevicePositionParams{
+		ID:  id,
+		Lat: lat,
+		Lon: lon,
+		Alt: alt,
+	})
+	if err != nil {
+		return DeviceView{}, err
 	}
+	slog.Info("position override set", "id", id, "lat", lat, "lon", lon)
+	return s.deviceChanged(ctx, device, release)
+}
 
-	msg := DeviceMessage{Type: "devices", Data: views}
-	data, err := json.Marshal(msg)
+// ClearPositionOverride lets reported positions update the device again. The
+// pinned position is kept until the next report.
+func (s *Subscriber) ClearPositionOverride(ctx context.Context, id string) (DeviceView, error) {
+	unlock := s.devices.lock(id)
+	defer unlock()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		return DeviceView{}, err
+	}
+	defer release()
+
+	device, err := s.queries.ClearDevicePositionOverride(ctx, id)
+	if err != nil {
+		return DeviceView{}, err
+	}
+	slog.Info("position override cleared", "id", id)
+	return s.deviceChanged(ctx, device, release)
+}
+
+// deviceChanged notifies hooks and browsers about a device changed outside
+// the MQTT path and returns its view. release is called once the view is
+// loaded.
+func (s *Subscriber) deviceChanged(ctx context.Context, device db.Device, release func()) (DeviceView, error) {
+	v, err := s.deviceView(ctx, device)
+	release()
 	if err != nil {
-		slog.Error("failed to marshal device message", "err", err)
+		return DeviceView{}, err
+	}
+	s.notifyHooks(v)
+	s.broadcastDevices(&device)
+	return v, nil
+}
+
+// broadcastDevice broadcasts an update of device received over MQTT, after
+// BroadcastWindow if set. prevChannel is the channel the device was on
+// before the update, so clients of that channel see it leave. A device
+// already waiting for its broadcast is not scheduled again; the broadcast
+// sends its state when the window ends. During warm-up nothing is sent; the
+// snapshot at its end covers the update.
+func (s *Subscriber) broadcastDevice(device db.Device, prevChannel string) {
+	if s.warmup != nil && s.warmup.warming() {
+		return
+	}
+	if s.opts.BroadcastWindow <= 0 {
+		s.broadcastDevices(&device, prevChannel)
 		return
 	}
 
-	broadcastCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	s.cm.BroadcastAll(broadcastCtx, data)
+	s.coalesceMu.Lock()
+	defer s.coalesceMu.Unlock()
+	prev, waiting := s.coalescing[device.ID]
+	s.coalescing[device.ID] = append(prev, prevChannel)
+	if waiting {
+		return
+	}
+	time.AfterFunc(s.opts.BroadcastWindow, func() {
+		s.coalesceMu.Lock()
+		prevChannels := s.coalescing[device.ID]
+		delete(s.coalescing, device.ID)
+		s.coalesceMu.Unlock()
+
+		// The flush goroutine loads the device as it is by then.
+		s.broadcastDevices(&db.Device{ID: device.ID}, prevChannels...)
+	})
+}
+
+// broadcastDevices queues a broadcast of the device list to WebSocket
+// clients after changed was updated, or after an update affecting many
+// devices when changed is nil. prevChannels are the channels changed was on
+// before. The change is recorded for the flush goroutine started by
+// StartBroadcastFlush, which sends it.
+func (s *Subscriber) broadcastDevices(changed *db.Device, prevChannels ...string) {
+	s.dirty.mark(changed, prevChannels...)
+}
+
+// sendDevices sends the device list to WebSocket clients and device socket
+// readers. The global room and socket readers always receive the full list.
+// Filtered rooms only receive their matching snapshot, and channel rooms only
+// when the changed device is on that channel. Clients that negotiated deltas
+// receive just the changed device, or its removal when it no longer matches
+// their filter. Rooms of prevChannels, the channels the changed device was
+// on before, receive the change too, so their clients see it leave. views
+// is the current list of every device.
+func (s *Subscriber) sendDevices(views []DeviceView, changed *db.Device, prevChannels []string) {
+	if s.socket.Count() > 0 {
+		msg, err := marshalDevices(views)
+		if err != nil {
+			slog.Error("failed to marshal device message", "err", err)
+			return
+		}
+		s.socket.Publish(socketLine(msg))
+	}
+
+	var channel string
+	var changedView *DeviceView
+	if changed != nil {
+		channel = changed.Channel
+		if i := slices.IndexFunc(views, func(v DeviceView) bool { return v.ID == changed.ID }); i >= 0 {
+			changedView = &views[i]
+		}
+	}
+
+	for _, room := range s.cm.Rooms() {
+		filter := roomFilter(room)
+		if channel != "" && filter.Channel != "" && filter.Channel != channel && !slices.Contains(prevChannels, filter.Channel) {
+			continue
+		}
+
+		data, err := marshalDevices(filter.apply(views))
+		if err != nil {
+			slog.Error("failed to marshal device message", "err", err)
+			return
+		}
+
+		var delta *wsMessage
+		if changed != nil {
+			if changedView != nil && filter.match(*changedView) {
+				delta, err = newWSMessage(DeviceDeltaMessage{Type: "device", Data: *changedView})
+			} else {
+				delta, err = newWSMessage(DeviceRemovedMessage{Type: "remove", ID: changed.ID})
+			}
+			if err != nil {
+				slog.Error("failed to marshal device delta", "err", err)
+				return
+			}
+		}
+		s.cm.BroadcastUpdate(room, data, delta)
+	}
 }
 
-// StartCleanup runs a background goroutine that removes devices not seen in 48h.
-func (s *Subscriber) StartCleanup(ctx context.Context, interval time.Duration) {
+// StartCleanup runs a background goroutine that removes devices not seen for
+// StaleAfter and prunes history older than the retention window, about every
+// interval and, with runNow, once right away. The goroutine stops when ctx is
+// cancelled and closes the returned channel once any cleanup in progress has
+// finished, so the caller can wait for it before closing the database.
+func (s *Subscriber) StartCleanup(ctx context.Context, interval time.Duration, runNow bool) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+		defer close(done)
+		wait := cleanupDelay(interval)
+		if runNow {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		var last time.Time
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				deleteCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-				if err := s.queries.DeleteStaleDevices(deleteCtx); err != nil {
-					slog.Error("failed to delete stale devices", "err", err)
+			case <-timer.C:
+			}
+			start := time.Now()
+			cleanupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			s.cleanup(cleanupCtx)
+			cancel()
+
+			attrs := []any{"took", time.Since(start).Round(time.Millisecond).String()}
+			if !last.IsZero() {
+				since := start.Sub(last)
+				attrs = append(attrs, "since_last", since.Round(time.Second).String())
+				if since > 2*interval {
+					slog.Warn("cleanup ran late", append(attrs, "interval", interval.String())...)
 				} else {
-					s.broadcastDevices(deleteCtx)
+					slog.Info("cleanup finished", attrs...)
 				}
-				cancel()
+			} else {
+				slog.Info("cleanup finished", attrs...)
 			}
+			last = start
+			// The next run is scheduled from the end of this one, so a run
+			// delayed by load is followed by a full interval instead of a
+			// burst of catch-up runs.
+			timer.Reset(cleanupDelay(interval))
 		}
 	}()
+	return done
+}
+
+// cleanupDelay returns interval give or take up to 10% at random, so that
+// instances started together do not clean up in lockstep.
+func cleanupDelay(interval time.Duration) time.Duration {
+	return interval - interval/10 + rand.N(interval/5+1)
 }
 
-// LoadAndBroadcast fetches current devices from DB and returns serialised JSON.
-func (s *Subscriber) LoadAndBroadcast(ctx context.Context) ([]byte, error) {
+func (s *Subscriber) cleanup(ctx context.Context) {
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "err", err)
+		return
+	}
+	defer release()
+
+	// Silent devices are greyed out first and only removed once stale.
+	changed := s.reconcileOnline(ctx)
+	if s.opts.StaleAfter > 0 {
+		ids, err := s.queries.DeleteStaleDevices(ctx, time.Now().Add(-s.opts.StaleAfter).UTC())
+		if err != nil {
+			slog.Error("failed to delete stale devices", "err", err)
+		} else if len(ids) > 0 {
+			slog.Info("stale devices removed", "count", len(ids))
+			for _, fn := range s.onRemove {
+				fn(ids)
+			}
+			changed = true
+		}
+	}
+	if changed {
+		s.broadcastDevices(nil)
+	}
+	if err := s.queries.DeleteStaleGatewaySamples(ctx); err != nil {
+		slog.Error("failed to delete stale gateway samples", "err", err)
+	}
+	if err := s.queries.DeleteStaleRouteHops(ctx); err != nil {
+		slog.Error("failed to delete stale routes", "err", err)
+	}
+	if err := s.queries.DeleteStaleRoutingResults(ctx); err != nil {
+		slog.Error("failed to delete stale routing results", "err", err)
+	}
+	s.pruneHistory(ctx)
+	s.pruneRawPackets(ctx)
+	s.pruneMessages(ctx)
+	if s.pending != nil {
+		// Holds of devices without a fix for StaleAfter, or two days
+		// when stale devices are kept, are dropped. Devices that keep
+		// reporting stay held.
+		keep := s.opts.StaleAfter
+		if keep <= 0 {
+			keep = 48 * time.Hour
+		}
+		s.pending.prune(time.Now().Add(-keep))
+	}
+	if s.deviceErrors != nil {
+		keep := s.opts.StaleAfter
+		if keep <= 0 {
+			keep = 48 * time.Hour
+		}
+		s.deviceErrors.prune(time.Now().Add(-keep))
+	}
+}
+
+// ReconcileOnline recomputes the online flag of every device from its last
+// seen time and broadcasts the result. It is run at startup, so devices that
+// were online when the server stopped are not shown online after an outage.
+func (s *Subscriber) ReconcileOnline(ctx context.Context) {
+	if s.reconcileOnline(ctx) {
+		s.broadcastDevices(nil)
+	}
+}
+
+// online reports whether d is online at now. With OfflineAfter set it is
+// computed from the last seen time, so views are current between the
+// cleanups that update the stored flag; otherwise the stored flag is used.
+func (s *Subscriber) online(d db.Device, now time.Time) bool {
+	if s.opts.OfflineAfter <= 0 {
+		return d.Online != 0
+	}
+	return !d.LastSeen.Before(now.Add(-s.opts.OfflineAfter))
+}
+
+// reconcileOnline marks devices silent for longer than OfflineAfter offline
+// and the others online, reporting whether any device changed.
+func (s *Subscriber) reconcileOnline(ctx context.Context) bool {
+	if s.opts.OfflineAfter <= 0 {
+		return false
+	}
+	n, err := s.queries.ReconcileDevicesOnline(ctx, time.Now().Add(-s.opts.OfflineAfter).UTC())
+	if err != nil {
+		slog.Error("failed to reconcile online flags", "err", err)
+		return false
+	}
+	if n > 0 {
+		slog.Info("online flags reconciled", "changed", n)
+	}
+	return n > 0
+}
+
+// LoadAndBroadcast fetches current devices from DB and returns the snapshot
+// message for the devices matching filter, cut to SnapshotLimit.
+func (s *Subscriber) LoadAndBroadcast(ctx context.Context, filter deviceFilter) (*wsMessage, error) {
+	views, err := s.ListViews(ctx)
+	if err != nil {
+		return nil, err
+	}
+	views = filter.apply(views)
+	if limit := s.opts.SnapshotLimit; limit > 0 && len(views) > limit {
+		// Views are most recently seen first.
+		return newWSMessage(DeviceMessage{Type: "devices", Data: views[:limit], Truncated: true, Total: len(views)})
+	}
+	return marshalDevices(views)
+}
+
+// ListViews returns the browser-facing view of every stored device, most
+// recently seen first.
+func (s *Subscriber) ListViews(ctx context.Context) ([]DeviceView, error) {
 	devices, err := s.queries.ListDevices(ctx)
 	if err != nil {
 		return nil, err
 	}
+	tags, err := s.queries.ListDeviceTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tagsByDevice := make(map[string][]string)
+	for _, t := range tags {
+		tagsByDevice[t.DeviceID] = append(tagsByDevice[t.DeviceID], t.Tag)
+	}
+	samples, err := s.queries.ListGatewaySamples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reception := bestGateways(samples)
+	stats, err := s.queries.ListRoutingStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reliability := deviceReliability(stats)
+	enrichment, err := s.queries.ListDeviceEnrichment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	enrichmentByDevice := make(map[string]db.DeviceEnrichment, len(enrichment))
+	for _, e := range enrichment {
+		enrichmentByDevice[e.DeviceID] = e
+	}
 
+	now := time.Now()
 	views := make([]DeviceView, 0, len(devices))
 	for _, d := range devices {
-		views = append(views, deviceToView(d))
+		if s.isPending(d.ID) {
+			continue
+		}
+		v := deviceToView(d)
+		v.Online = s.online(d, now)
+		v.Class = s.classify(d)
+		v.Lat, v.Lon = s.publicPosition(v.Lat, v.Lon)
+		v.Tags = tagsByDevice[d.ID]
+		if rec, ok := reception[d.ID]; ok {
+			g := rec.Best
+			v.RSSI, v.SNR, v.Gateway, v.HopsAway = g.RSSI, g.SNR, g.Gateway, g.HopsAway
+			v.GatewayCount = rec.Count
+		}
+		if r, ok := reliability[d.ID]; ok {
+			v.Reliability = &r
+		}
+		if e, ok := enrichmentByDevice[d.ID]; ok {
+			v.Owner, v.Team, v.Notes = e.Owner, e.Team, e.Notes
+		}
+		views = append(views, v)
 	}
+	if s.opts.DisambiguateNames {
+		disambiguateNames(views)
+	}
+	return views, nil
+}
 
+// View returns the view of a single device without listing the others, or
+// sql.ErrNoRows if it is unknown or held back as new. Its display name is
+// not disambiguated, as that needs every device.
+func (s *Subscriber) View(ctx context.Context, id string) (DeviceView, error) {
+	if s.isPending(id) {
+		return DeviceView{}, sql.ErrNoRows
+	}
+	device, err := s.queries.GetDevice(ctx, id)
+	if err != nil {
+		return DeviceView{}, err
+	}
+	return s.deviceView(ctx, device)
+}
+
+// deviceView builds the view of a single device as ListViews does, loading
+// its tags, reception, reliability and enrichment.
+func (s *Subscriber) deviceView(ctx context.Context, device db.Device) (DeviceView, error) {
+	id := device.ID
+	v := deviceToView(device)
+	v.Online = s.online(device, time.Now())
+	v.Class = s.classify(device)
+	v.Lat, v.Lon = s.publicPosition(v.Lat, v.Lon)
+	var err error
+	if v.Tags, err = s.queries.ListTagsForDevice(ctx, id); err != nil {
+		return DeviceView{}, err
+	}
+	samples, err := s.queries.ListGatewaySamplesForDevice(ctx, id)
+	if err != nil {
+		return DeviceView{}, err
+	}
+	if rec, ok := bestGateways(samples)[id]; ok {
+		g := rec.Best
+		v.RSSI, v.SNR, v.Gateway, v.HopsAway = g.RSSI, g.SNR, g.Gateway, g.HopsAway
+		v.GatewayCount = rec.Count
+	}
+	stats, err := s.queries.ListRoutingStats(ctx)
+	if err != nil {
+		return DeviceView{}, err
+	}
+	if r, ok := deviceReliability(stats)[id]; ok {
+		v.Reliability = &r
+	}
+	e, err := s.queries.GetDeviceEnrichment(ctx, id)
+	switch {
+	case err == nil:
+		v.Owner, v.Team, v.Notes = e.Owner, e.Team, e.Notes
+	case !errors.Is(err, sql.ErrNoRows):
+		return DeviceView{}, err
+	}
+	return v, nil
+}
+
+func marshalDevices(views []DeviceView) (*wsMessage, error) {
 	msg := DeviceMessage{Type: "devices", Data: views}
-	return json.Marshal(msg)
+	return newWSMessage(msg)
 }
 
 // isMeshtasticJSONTopic returns true for topics matching msh/.../2/json/...
@@ -259,16 +1194,57 @@ func isMeshtasticJSONTopic(topic string) bool {
 	return len(parts) >= 5 && parts[0] == "msh" && parts[2] == "2" && parts[3] == "json"
 }
 
+// topicChannel returns the channel name from msh/{region}/2/json/{channel}/...
+func topicChannel(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
+
 func deviceToView(d db.Device) DeviceView {
 	return DeviceView{
-		ID:           d.ID,
-		Lat:          d.Lat,
-		Lon:          d.Lon,
-		Alt:          d.Alt,
-		Speed:        d.Speed,
-		Sats:         d.Sats,
-		BatteryLevel: d.BatteryMv, // stored as battery_level (0-100)
-		Online:       d.Online != 0,
-		LastSeen:     d.LastSeen.UTC(),
+		ID:             d.ID,
+		Lat:            d.Lat,
+		Lon:            d.Lon,
+		Alt:            d.Alt,
+		Speed:          d.Speed,
+		Course:         d.Course,
+		Sats:           d.Sats,
+		BatteryLevel:   d.BatteryMv, // stored as battery_level (0-100)
+		Voltage:        d.BatteryVoltage,
+		Online:         d.Online != 0,
+		LastSeen:       d.LastSeen.UTC(),
+		Channel:        d.Channel,
+		RTCUnset:       d.RtcUnset != 0,
+		Override:       d.PositionOverride != 0,
+		LongName:       d.LongName,
+		ShortName:      d.ShortName,
+		DisplayName:    d.ShortName,
+		RSSI:           d.Rssi,
+		SNR:            d.Snr,
+		PositionSource: d.PositionSource,
+		CourseSource:   d.CourseSource,
+		Role:           d.Role,
+	}
+}
+
+// plausibleTimestamp reports whether a packet timestamp looks like it came
+// from a node with a set clock: not zero, not before 2020 and not more than a
+// day in the future.
+func plausibleTimestamp(ts int64, now time.Time) bool {
+	return ts >= minPlausibleTimestamp && ts <= now.Add(24*time.Hour).Unix()
+}
+
+// hasFix reports whether the device has reported a GPS position.
+func hasFix(v DeviceView) bool {
+	return v.Lat != 0 || v.Lon != 0
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+	"github.com/ncruces/go-sqlite3/vfs/memdb"
+)
+
+// newTestSubscriber returns a subscriber backed by a fresh in-memory
+// database with the schema applied.
+func newTestSubscriber(t *testing.T, cm *ConnectionManager, opts SubscriberOptions) *Subscriber {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite3", memdb.TestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if _, err := sqlDB.Exec(schema); err != nil {
+		t.Fatalf("apply schema: %v", err)
+	}
+	if err := applyMigrations(sqlDB); err != nil {
+		t.Fatalf("apply migrations: %v", err)
+	}
+	if cm == nil {
+		cm = NewConnectionManager(ConnectionOptions{})
+	}
+	return NewSubscriber(sqlDB, cm, opts)
+}
+
+// publishPacket hands a Meshtastic JSON packet from node to s as the broker
+// would.
+func publishPacket(t *testing.T, s *Subscriber, node uint32, typ string, payload any) {
+	t.Helper()
+	publishPacketOn(t, s, "LongFast", node, typ, payload)
+}
+
+// publishPacketOn is publishPacket on the given channel.
+func publishPacketOn(t *testing.T, s *Subscriber, channel string, node uint32, typ string, payload any) {
+	t.Helper()
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := json.Marshal(MeshtasticPacket{
+		From:      node,
+		Sender:    nodeID(node),
+		Timestamp: time.Now().Unix(),
+		Type:      typ,
+		Payload:   raw,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleMessage(fmt.Sprintf("msh/US/2/json/%s/%s", channel, nodeID(node)), pkt)
+}
+
+func TestBroadcastsInterleavedWithCleanup(t *testing.T) {
+	cm := NewConnectionManager(ConnectionOptions{})
+	client := cm.NewClient(nil, "test")
+	cm.Add(globalRoom, client)
+	s := newTestSubscriber(t, cm, SubscriberOptions{StaleAfter: time.Hour})
+
+	const moving, stale = 0x1000, 0x2000
+	for _, node := range []uint32{moving, stale} {
+		publishPacket(t, s, node, "position", PositionPayload{LatitudeI: 515000000, LongitudeI: -1000000})
+	}
+	if _, err := s.sqlDB.Exec(`UPDATE devices SET last_seen = datetime('now', '-2 hours') WHERE id = ?`, nodeID(stale)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := s.StartBroadcastFlush(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.cleanup(context.Background())
+	}()
+	go func() {
+		defer wg.Done()
+		publishPacket(t, s, moving, "position", PositionPayload{LatitudeI: 516000000, LongitudeI: -1000000})
+	}()
+	wg.Wait()
+
+	// Let the flush goroutine send everything marked, then stop it.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.dirty.mu.Lock()
+		pending := s.dirty.all || len(s.dirty.ids) > 0
+		s.dirty.mu.Unlock()
+		if !pending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("broadcasts were not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	client.mu.Lock()
+	queue := client.queue
+	client.mu.Unlock()
+	if len(queue) == 0 {
+		t.Fatal("no snapshot was sent")
+	}
+	var last DeviceMessage
+	if err := json.Unmarshal(queue[len(queue)-1].msg.json, &last); err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Data) != 1 || last.Data[0].ID != nodeID(moving) {
+		t.Fatalf("last snapshot has %+v, want only %s", last.Data, nodeID(moving))
+	}
+	if got, want := last.Data[0].Lat, float64(516000000)*1e-7; got != want {
+		t.Errorf("last snapshot has lat %v, want the latest fix %v", got, want)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestPositionAltitude(t *testing.T) {
+	tests := []struct {
+		name     string
+		altitude *float64
+		want     float64
+	}{
+		{"absent keeps stored altitude", nil, 120},
+		{"zero is stored", ptr(0.0), 0},
+		{"present is stored", ptr(300.0), 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSubscriber(t, nil, SubscriberOptions{AltitudeUnit: AltitudeMeters})
+			const node = 0x1000
+			publishPacket(t, s, node, "position", PositionPayload{LatitudeI: 515000000, LongitudeI: -1000000, Altitude: ptr(120.0)})
+			publishPacket(t, s, node, "position", PositionPayload{LatitudeI: 515000100, LongitudeI: -1000000, Altitude: tt.altitude})
+
+			device, err := s.queries.GetDevice(context.Background(), nodeID(node))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if device.Alt != tt.want {
+				t.Errorf("altitude = %v, want %v", device.Alt, tt.want)
+			}
+		})
+	}
+}
+
+func TestPositionOutOfRange(t *testing.T) {
+	tests := []struct {
+		name                  string
+		latitudeI, longitudeI int64
+	}{
+		{"latitude", 2000000000, -1000000},
+		{"negative latitude", -2000000000, -1000000},
+		{"longitude", 515000000, 2000000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSubscriber(t, nil, SubscriberOptions{})
+			const node = 0x1000
+			publishPacket(t, s, node, "position", PositionPayload{LatitudeI: tt.latitudeI, LongitudeI: tt.longitudeI})
+
+			_, err := s.queries.GetDevice(context.Background(), nodeID(node))
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("GetDevice error = %v, want sql.ErrNoRows", err)
+			}
+		})
+	}
+}
+
+func TestValidNodeNum(t *testing.T) {
+	tests := []struct {
+		n    uint32
+		want bool
+	}{
+		{0, false},
+		{0xffffffff, false},
+		{1, true},
+		{0xdeadbeef, true},
+		{0xfffffffe, true},
+	}
+	for _, tt := range tests {
+		if got := validNodeNum(tt.n); got != tt.want {
+			t.Errorf("validNodeNum(%#x) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestOnline(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		offlineAfter time.Duration
+		device       db.Device
+		want         bool
+	}{
+		{"stored online", 0, db.Device{Online: 1, LastSeen: now.Add(-48 * time.Hour)}, true},
+		{"stored offline", 0, db.Device{Online: 0, LastSeen: now}, false},
+		{"seen recently", time.Hour, db.Device{Online: 0, LastSeen: now.Add(-30 * time.Minute)}, true},
+		{"seen at cutoff", time.Hour, db.Device{Online: 0, LastSeen: now.Add(-time.Hour)}, true},
+		{"silent too long", time.Hour, db.Device{Online: 1, LastSeen: now.Add(-2 * time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Subscriber{opts: SubscriberOptions{OfflineAfter: tt.offlineAfter}}
+			if got := s.online(tt.device, now); got != tt.want {
+				t.Errorf("online() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanupDelay(t *testing.T) {
+	for _, interval := range []time.Duration{time.Second, 15 * time.Minute, time.Hour} {
+		low, high := interval-interval/10, interval+interval/10
+		for range 1000 {
+			if d := cleanupDelay(interval); d < low || d > high {
+				t.Fatalf("cleanupDelay(%v) = %v, want within [%v, %v]", interval, d, low, high)
+			}
+		}
+	}
+}
+
+func TestStartCleanupStops(t *testing.T) {
+	s := newTestSubscriber(t, nil, SubscriberOptions{StaleAfter: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := s.StartCleanup(ctx, time.Millisecond, true)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cleanup goroutine did not stop after its context was cancelled")
+	}
+}
+
+func TestPacketIdentifiedByFrom(t *testing.T) {
+	s := newTestSubscriber(t, nil, SubscriberOptions{})
+	const node, gateway = 0x1000, 0x2000
+	raw, _ := json.Marshal(PositionPayload{LatitudeI: 515000000, LongitudeI: -1000000})
+	pkt, _ := json.Marshal(MeshtasticPacket{
+		From:      node,
+		Sender:    nodeID(gateway),
+		Timestamp: time.Now().Unix(),
+		Type:      "position",
+		Payload:   raw,
+	})
+	s.HandleMessage("msh/US/2/json/LongFast/"+nodeID(gateway), pkt)
+
+	ctx := context.Background()
+	if _, err := s.queries.GetDevice(ctx, nodeID(node)); err != nil {
+		t.Errorf("GetDevice(from): %v", err)
+	}
+	if _, err := s.queries.GetDevice(ctx, nodeID(gateway)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetDevice(sender) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestHoldNewDevices(t *testing.T) {
+	s := newTestSubscriber(t, nil, SubscriberOptions{HoldNewDevices: true, HoldFixes: 2, HoldRadius: 200})
+	const sensor, tracker = 0x1000, 0x2000
+	publishPacket(t, s, sensor, "telemetry", TelemetryPayload{BatteryLevel: 80, Voltage: 3.9})
+	publishPacket(t, s, tracker, "position", PositionPayload{LatitudeI: 515000000, LongitudeI: -1000000})
+
+	listed := func() map[string]bool {
+		views, err := s.ListViews(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make(map[string]bool)
+		for _, v := range views {
+			ids[v.ID] = true
+		}
+		return ids
+	}
+	ids := listed()
+	if !ids[nodeID(sensor)] {
+		t.Errorf("telemetry-only device %s is held back", nodeID(sensor))
+	}
+	if ids[nodeID(tracker)] {
+		t.Errorf("device %s with a single fix is not held back", nodeID(tracker))
+	}
+
+	publishPacket(t, s, tracker, "position", PositionPayload{LatitudeI: 515000100, LongitudeI: -1000000})
+	if !listed()[nodeID(tracker)] {
+		t.Errorf("device %s is still held back after a stable fix", nodeID(tracker))
+	}
+}
+
+func TestChannelChangeReachesPreviousRoom(t *testing.T) {
+	cm := NewConnectionManager(ConnectionOptions{})
+	clients := make(map[string]*wsClient)
+	for _, channel := range []string{"A", "B"} {
+		c := cm.NewClient(nil, channel)
+		c.setCapabilities([]string{capabilityDelta})
+		cm.Add(deviceFilter{Channel: channel}.room(), c)
+		clients[channel] = c
+	}
+	s := newTestSubscriber(t, cm, SubscriberOptions{})
+	ctx := context.Background()
+
+	const node = 0x1000
+	publishPacketOn(t, s, "A", node, "position", PositionPayload{LatitudeI: 515000000, LongitudeI: -1000000})
+	s.flushBroadcasts(ctx)
+	publishPacketOn(t, s, "B", node, "position", PositionPayload{LatitudeI: 515000100, LongitudeI: -1000000})
+	s.flushBroadcasts(ctx)
+
+	lastType := func(channel string) (string, string) {
+		c := clients[channel]
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if len(c.queue) == 0 {
+			t.Fatalf("nothing sent to the channel %s room", channel)
+		}
+		var msg struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(c.queue[len(c.queue)-1].msg.json, &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg.Type, msg.ID + msg.Data.ID
+	}
+	if typ, id := lastType("A"); typ != "remove" || id != nodeID(node) {
+		t.Errorf("channel A room last got %s for %q, want remove for %s", typ, id, nodeID(node))
+	}
+	if typ, id := lastType("B"); typ != "device" || id != nodeID(node) {
+		t.Errorf("channel B room last got %s for %q, want device for %s", typ, id, nodeID(node))
+	}
+}
+
+func TestSetTags(t *testing.T) {
+	s := newTestSubscriber(t, nil, SubscriberOptions{})
+	ctx := context.Background()
+	const node = 0x1000
+	publishPacket(t, s, node, "position", PositionPayload{LatitudeI: 515000000, LongitudeI: -1000000})
+	s.dirty.take()
+
+	if _, err := s.SetTags(ctx, nodeID(node), []string{" "}); !errors.Is(err, errInvalidTags) {
+		t.Errorf("SetTags with an empty tag: err = %v, want errInvalidTags", err)
+	}
+	tags, err := s.SetTags(ctx, nodeID(node), []string{"b", " a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(tags) != "[a b]" {
+		t.Errorf("tags = %v, want [a b]", tags)
+	}
+	ids, _, all := s.dirty.take()
+	if all || len(ids) != 1 || ids[0] != nodeID(node) {
+		t.Errorf("dirty after SetTags = %v (all %v), want only %s", ids, all, nodeID(node))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// maxTagLength bounds the length of a single device tag.
+const maxTagLength = 64
+
+// errInvalidTags is returned by SetTags for empty or overlong tags.
+var errInvalidTags = errors.New("invalid tags")
+
+// normalizeTags trims, de-duplicates and sorts tags, rejecting empty or
+// overlong ones.
+func normalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || len(t) > maxTagLength {
+			return nil, fmt.Errorf("%w: want 1-%d characters each", errInvalidTags, maxTagLength)
+		}
+		out = append(out, t)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// SetTags replaces the tags of a device and broadcasts the change, returning
+// errInvalidTags for tags normalizeTags rejects. Tags may
+// be assigned before a device is first heard and are kept when a stale device
+// is removed, so they apply again when it returns.
+func (s *Subscriber) SetTags(ctx context.Context, id string, tags []string) ([]string, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Readers never see the device without tags halfway through.
+	err = s.inTx(ctx, func(q *db.Queries) error {
+		if err := q.DeleteDeviceTags(ctx, id); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if err := q.AddDeviceTag(ctx, db.AddDeviceTagParams{DeviceID: id, Tag: tag}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("device tags set", "id", id, "tags", tags)
+	// Hooks such as the interpolator filter by tag too, so they get the
+	// new view of a stored device.
+	device, err := s.queries.GetDevice(ctx, id)
+	switch {
+	case err == nil:
+		if !s.isPending(id) {
+			s.notifyUpdate(ctx, device, release)
+			// The delta moves the device in or out of tag rooms.
+			s.broadcastDevices(&device)
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		slog.Warn("failed to load device for hooks", "id", id, "err", err)
+	}
+	return tags, nil
+}
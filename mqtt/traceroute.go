@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// Route directions.
+const (
+	RouteTowards = "towards"
+	RouteBack    = "back"
+)
+
+// snrUnknown marks a hop whose SNR was not recorded, e.g. on nodes running
+// firmware that predates per-hop SNR.
+const snrUnknown = math.MinInt8
+
+// TraceroutePayload is the payload for type=traceroute packets. Only
+// traceroute responses are uplinked, so the envelope's from is the traced
+// node and to the node that requested the trace. Route lists the node
+// numbers between them; SNR values are in quarter dB, one per hop received.
+type TraceroutePayload struct {
+	Route      []uint32 `json:"route"`
+	SNRTowards []int64  `json:"snr_towards"`
+	RouteBack  []uint32 `json:"route_back"`
+	SNRBack    []int64  `json:"snr_back"`
+}
+
+// RouteHop is one node on a traced path. SNR is how well the node heard the
+// previous hop, nil for the first node and when unknown.
+type RouteHop struct {
+	NodeID string   `json:"node_id"`
+	SNR    *float64 `json:"snr"`
+}
+
+// Route is the latest traced path between a device and the node that traced
+// it. Towards runs from the requester to the device, Back the other way and
+// is empty when the response did not record its return path.
+type Route struct {
+	DeviceID string     `json:"device_id"`
+	Towards  []RouteHop `json:"towards"`
+	Back     []RouteHop `json:"back"`
+	HeardAt  time.Time  `json:"heard_at"`
+}
+
+// routeHops builds the full path from origin through route to dest, pairing
+// each hop after the origin with its SNR.
+func routeHops(origin string, route []uint32, dest string, snr []int64) []RouteHop {
+	nodes := make([]string, 0, len(route)+2)
+	nodes = append(nodes, origin)
+	for _, n := range route {
+		nodes = append(nodes, nodeID(n))
+	}
+	nodes = append(nodes, dest)
+
+	hops := make([]RouteHop, len(nodes))
+	for i, n := range nodes {
+		hops[i].NodeID = n
+		if i > 0 && i-1 < len(snr) && snr[i-1] != snrUnknown {
+			v := float64(snr[i-1]) / 4
+			hops[i].SNR = &v
+		}
+	}
+	return hops
+}
+
+// handleTraceroute replaces the stored route of the traced device. It does
+// not touch the device itself.
+func (s *Subscriber) handleTraceroute(info packetInfo, to uint32, raw json.RawMessage) {
+	var p TraceroutePayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		s.payloadError(info, "traceroute payload", err)
+		return
+	}
+
+	if !validNodeNum(to) {
+		s.payloadError(info, "traceroute payload", fmt.Errorf("invalid to node number %#x", to))
+		return
+	}
+	requester := nodeID(to)
+	towards := routeHops(requester, p.Route, info.id, p.SNRTowards)
+	var back []RouteHop
+	if len(p.RouteBack) > 0 || len(p.SNRBack) > 0 {
+		back = routeHops(info.id, p.RouteBack, requester, p.SNRBack)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release, err := s.acquireDB(ctx)
+	if err != nil {
+		slog.Warn("timed out waiting for database", "id", info.id, "err", err)
+		return
+	}
+	defer release()
+
+	// Replace the route in one transaction so readers never see it empty.
+	err = s.inTx(ctx, func(q *db.Queries) error {
+		if err := q.DeleteRouteHops(ctx, info.id); err != nil {
+			return err
+		}
+		for direction, hops := range map[string][]RouteHop{RouteTowards: towards, RouteBack: back} {
+			for i, h := range hops {
+				err := q.AddRouteHop(ctx, db.AddRouteHopParams{
+					DeviceID:  info.id,
+					Direction: direction,
+					Hop:       int64(i),
+					NodeID:    h.NodeID,
+					Snr:       nullFloat(h.SNR),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to store route", "id", info.id, "err", err)
+		return
+	}
+	slog.Debug("traceroute stored", "id", info.id, "requester", requester, "hops", len(p.Route))
+}
+
+// Route returns the latest traced path to a device, or sql.ErrNoRows if
+// none has been heard.
+func (s *Subscriber) Route(ctx context.Context, id string) (Route, error) {
+	rows, err := s.queries.ListRouteHops(ctx, id)
+	if err != nil {
+		return Route{}, err
+	}
+	if len(rows) == 0 {
+		return Route{}, sql.ErrNoRows
+	}
+	r := Route{DeviceID: id, Towards: []RouteHop{}, Back: []RouteHop{}}
+	for _, row := range rows {
+		h := RouteHop{NodeID: row.NodeID, SNR: floatPtr(row.Snr)}
+		if row.Direction == RouteBack {
+			r.Back = append(r.Back, h)
+		} else {
+			r.Towards = append(r.Towards, h)
+		}
+		if row.HeardAt.After(r.HeardAt) {
+			r.HeardAt = row.HeardAt
+		}
+	}
+	return r, nil
+}
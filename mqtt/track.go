@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jarv/mqtt/db"
+)
+
+// geoJSONFeature is a GeoJSON Feature with a LineString geometry.
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties trackProperties   `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type string `json:"type"`
+	// Coordinates are [lon, lat, alt] as GeoJSON requires.
+	Coordinates [][3]float64 `json:"coordinates"`
+}
+
+// geoJSONFeatureCollection is returned instead of a Feature when a track has
+// too few points to form a valid LineString.
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type trackProperties struct {
+	ID string `json:"id"`
+	// Times holds the time of each coordinate, in the same order.
+	Times []time.Time `json:"times"`
+}
+
+// Track returns the positions recorded for id since the given time, oldest
+// first, quantized like device views.
+func (s *Subscriber) Track(ctx context.Context, id string, since time.Time) ([]db.Position, error) {
+	positions, err := s.queries.ListPositionsSince(ctx, db.ListPositionsSinceParams{DeviceID: id, Since: since.UTC()})
+	if err != nil {
+		return nil, err
+	}
+	for i := range positions {
+		positions[i].Lat, positions[i].Lon = s.publicPosition(positions[i].Lat, positions[i].Lon)
+	}
+	return positions, nil
+}
+
+// trackGeoJSON returns the track of id as a LineString Feature, or an empty
+// FeatureCollection if it has fewer than two points.
+func trackGeoJSON(id string, positions []db.Position) any {
+	if len(positions) < 2 {
+		return geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	}
+	f := geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geoJSONLineString{Type: "LineString", Coordinates: make([][3]float64, 0, len(positions))},
+		Properties: trackProperties{
+			ID:    id,
+			Times: make([]time.Time, 0, len(positions)),
+		},
+	}
+	for _, p := range positions {
+		f.Geometry.Coordinates = append(f.Geometry.Coordinates, [3]float64{p.Lon, p.Lat, p.Alt})
+		f.Properties.Times = append(f.Properties.Times, p.RecordedAt)
+	}
+	return f
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// ViewersMessage tells clients how many browsers are watching.
+type ViewersMessage struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// ViewerCounter broadcasts the number of connected clients after clients
+// connect or disconnect, at most once per interval, so connection churn costs
+// one message per interval instead of one per connect.
+type ViewerCounter struct {
+	cm       *ConnectionManager
+	interval time.Duration
+	changed  chan struct{}
+}
+
+func NewViewerCounter(cm *ConnectionManager, interval time.Duration) *ViewerCounter {
+	vc := &ViewerCounter{
+		cm:       cm,
+		interval: interval,
+		changed:  make(chan struct{}, 1),
+	}
+	cm.OnChange(vc.notify)
+	return vc
+}
+
+// notify records that clients connected or disconnected without blocking.
+func (vc *ViewerCounter) notify() {
+	select {
+	case vc.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run broadcasts the count after changes until ctx is cancelled. A change
+// within interval of the last broadcast is sent when the interval ends,
+// together with any further changes until then.
+func (vc *ViewerCounter) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	<-timer.C
+	pending := false
+	var sentAt time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-vc.changed:
+			if pending {
+				continue
+			}
+			pending = true
+			timer.Reset(max(vc.interval-time.Since(sentAt), 0))
+		case <-timer.C:
+			pending = false
+			msg, err := newWSMessage(ViewersMessage{Type: "viewers", Count: vc.cm.Count()})
+			if err != nil {
+				slog.Error("failed to marshal viewer count", "err", err)
+				continue
+			}
+			// Sent to everyone, not just on changes of the count, so a
+			// client that replaced one that left still learns it.
+			vc.cm.BroadcastAll(frameDelta, msg)
+			sentAt = time.Now()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// warmup suppresses per-device broadcasts while the first burst of packets
+// after startup is processed, e.g. gateways flushing their queues when the
+// broker comes back. It ends once no packet arrived for the quiet period, or
+// at the latest after the timeout, and then calls end once.
+type warmup struct {
+	quiet time.Duration
+	end   func()
+
+	mu       sync.Mutex
+	active   bool
+	packets  int
+	idle     *time.Timer
+	deadline *time.Timer
+}
+
+func newWarmup(quiet, timeout time.Duration, end func()) *warmup {
+	w := &warmup{quiet: quiet, end: end, active: true}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.idle = time.AfterFunc(quiet, w.finish)
+	w.deadline = time.AfterFunc(timeout, w.finish)
+	return w
+}
+
+// packet records a received packet, restarting the quiet period.
+func (w *warmup) packet() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.active {
+		w.packets++
+		w.idle.Reset(w.quiet)
+	}
+}
+
+// warming reports whether broadcasts are still suppressed.
+func (w *warmup) warming() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active
+}
+
+func (w *warmup) finish() {
+	w.mu.Lock()
+	if !w.active {
+		w.mu.Unlock()
+		return
+	}
+	w.active = false
+	w.idle.Stop()
+	w.deadline.Stop()
+	packets := w.packets
+	w.mu.Unlock()
+
+	slog.Info("warm-up finished, broadcasting snapshot", "packets", packets)
+	w.end()
+}
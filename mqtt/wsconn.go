@@ -1,45 +1,291 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"log/slog"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
+// globalRoom is the room for clients that want every device update.
+const globalRoom = "browsers"
+
+// frameKind classifies queued messages so redundant ones can be collapsed.
+type frameKind int
+
+const (
+	// frameSnapshot carries the full device list and supersedes any
+	// snapshot or delta still queued.
+	frameSnapshot frameKind = iota
+	// frameDelta carries a partial update.
+	frameDelta
+	// frameEvent carries a standalone event such as an alert; it is never
+	// collapsed.
+	frameEvent
+)
+
+type frame struct {
+	kind frameKind
+	msg  *wsMessage
+}
+
+// wsMessage is a server message shared by every client it is queued for. The
+// JSON encoding is built up front; the MessagePack encoding is built on first
+// use, so it costs nothing while no client has negotiated it.
+type wsMessage struct {
+	value any
+	json  []byte
+
+	msgpackOnce sync.Once
+	msgpack     []byte
+	msgpackErr  error
+}
+
+func newWSMessage(v any) (*wsMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &wsMessage{value: v, json: data}, nil
+}
+
+// encode returns the message as a text JSON frame, or as a binary
+// MessagePack frame using the same keys as the JSON encoding and the smallest
+// lossless representation of each number.
+func (m *wsMessage) encode(binary bool) (websocket.MessageType, []byte, error) {
+	if !binary {
+		return websocket.MessageText, m.json, nil
+	}
+	m.msgpackOnce.Do(func() {
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		enc.UseCompactInts(true)
+		enc.UseCompactFloats(true)
+		m.msgpackErr = enc.Encode(m.value)
+		m.msgpack = buf.Bytes()
+	})
+	return websocket.MessageBinary, m.msgpack, m.msgpackErr
+}
+
+// ConnectionOptions configures per-client write behaviour.
+type ConnectionOptions struct {
+	// Coalesce drops queued snapshots and deltas when a newer snapshot is
+	// queued, so a slow client catches up on the latest state instead of
+	// working through a stale backlog.
+	Coalesce bool
+	// MaxQueue bounds the frames queued for a client. A client whose queue
+	// overflows has its backlog dropped and is disconnected, so it
+	// reconnects with a fresh snapshot. Zero leaves queues unbounded.
+	MaxQueue int
+}
+
+// wsClient is a connected browser with its own outbound queue. A single
+// writer goroutine drains the queue, so a slow client never holds up a
+// broadcast to the others.
+type wsClient struct {
+	conn     *websocket.Conn
+	id       string
+	coalesce bool
+	maxQueue int
+	// sent counts the message bytes written to the client, as encoded.
+	sent atomic.Uint64
+	// dropped counts the frames discarded when the queue overflowed.
+	dropped atomic.Uint64
+	// closing is set once the server starts closing the connection;
+	// nothing is queued or written after that.
+	closing atomic.Bool
+
+	mu           sync.Mutex
+	queue        []frame
+	notify       chan struct{}
+	capabilities []string
+}
+
+// bytesSent returns the message bytes written to the client so far, not
+// counting WebSocket framing or compression.
+func (c *wsClient) bytesSent() uint64 {
+	return c.sent.Load()
+}
+
+// framesDropped returns the number of frames discarded because the client
+// could not keep up.
+func (c *wsClient) framesDropped() uint64 {
+	return c.dropped.Load()
+}
+
+// setCapabilities records the capabilities negotiated with the client.
+func (c *wsClient) setCapabilities(caps []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capabilities = caps
+}
+
+// supports reports whether the client negotiated capability.
+func (c *wsClient) supports(capability string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.capabilities, capability)
+}
+
+func newWSClient(conn *websocket.Conn, id string, opts ConnectionOptions) *wsClient {
+	return &wsClient{
+		conn:     conn,
+		id:       id,
+		coalesce: opts.Coalesce,
+		maxQueue: opts.MaxQueue,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// enqueue adds f to the client's queue without blocking.
+func (c *wsClient) enqueue(f frame) {
+	if c.closing.Load() {
+		return
+	}
+	c.mu.Lock()
+	if c.coalesce && f.kind == frameSnapshot {
+		c.queue = slices.DeleteFunc(c.queue, func(q frame) bool {
+			return q.kind != frameEvent
+		})
+	}
+	if c.maxQueue > 0 && len(c.queue) >= c.maxQueue {
+		c.overflow()
+		return
+	}
+	c.queue = append(c.queue, f)
+	c.mu.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// overflow discards the queue and the frame being queued, and closes the
+// connection. c.mu must be held; it is released.
+func (c *wsClient) overflow() {
+	dropped := len(c.queue) + 1
+	c.queue = nil
+	c.closing.Store(true)
+	c.mu.Unlock()
+
+	c.dropped.Add(uint64(dropped))
+	wsFramesDropped.Add(float64(dropped))
+	slog.Warn("WebSocket client queue full, closing connection", "client", c.id, "dropped", dropped)
+	// Broadcasts hold the manager lock, so do not wait for the close
+	// handshake here.
+	go func() {
+		_ = c.conn.Close(websocket.StatusTryAgainLater, "client too slow")
+	}()
+}
+
+// run writes queued frames until ctx is cancelled or a write fails, in which
+// case the connection is closed so the read loop tears the client down.
+func (c *wsClient) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.notify:
+		}
+
+		c.mu.Lock()
+		pending := c.queue
+		c.queue = nil
+		c.mu.Unlock()
+
+		binary := c.supports(capabilityMsgpack)
+		for _, f := range pending {
+			if c.closing.Load() {
+				return
+			}
+			typ, data, err := f.msg.encode(binary)
+			if err != nil {
+				slog.Error("failed to encode WebSocket message", "client", c.id, "err", err)
+				continue
+			}
+			writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err = c.conn.Write(writeCtx, typ, data)
+			cancel()
+			if err != nil {
+				// A write interrupted by the server closing the
+				// connection is expected.
+				if !c.closing.Load() {
+					slog.Warn("broadcast write failed", "client", c.id, "err", err)
+					_ = c.conn.CloseNow()
+				}
+				return
+			}
+			c.sent.Add(uint64(len(data)))
+			wsBytesSent.Add(float64(len(data)))
+		}
+	}
+}
+
 // ConnectionManager keeps track of active websocket connections.
 type ConnectionManager struct {
 	connections map[string]connectionInfo
 	mutex       sync.RWMutex
+	opts        ConnectionOptions
+	onChange    []func()
+	closing     bool
 }
 
 type connectionInfo struct {
-	conns []*websocket.Conn
-	name  string
+	clients []*wsClient
+	name    string
 }
 
-func NewConnectionManager() *ConnectionManager {
+func NewConnectionManager(opts ConnectionOptions) *ConnectionManager {
 	return &ConnectionManager{
 		connections: make(map[string]connectionInfo),
+		opts:        opts,
+	}
+}
+
+// NewClient wraps conn in a queued client using the manager's options.
+func (cm *ConnectionManager) NewClient(conn *websocket.Conn, id string) *wsClient {
+	return newWSClient(conn, id, cm.opts)
+}
+
+// OnChange registers fn to be called after a client is added or removed. It
+// must be called before clients connect.
+func (cm *ConnectionManager) OnChange(fn func()) {
+	cm.onChange = append(cm.onChange, fn)
+}
+
+func (cm *ConnectionManager) changed() {
+	for _, fn := range cm.onChange {
+		fn()
 	}
 }
 
-func (cm *ConnectionManager) Add(name string, conn *websocket.Conn) {
+func (cm *ConnectionManager) Add(name string, client *wsClient) {
+	defer cm.changed()
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
+	if cm.closing {
+		client.closing.Store(true)
+	}
 
 	info, exists := cm.connections[name]
 	if !exists {
 		info = connectionInfo{name: name}
 	}
-	info.conns = append(info.conns, conn)
+	info.clients = append(info.clients, client)
 	cm.connections[name] = info
 }
 
-func (cm *ConnectionManager) Remove(name string, conn *websocket.Conn) {
+func (cm *ConnectionManager) Remove(name string, client *wsClient) {
+	defer cm.changed()
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
 
@@ -48,46 +294,105 @@ func (cm *ConnectionManager) Remove(name string, conn *websocket.Conn) {
 		return
 	}
 
-	for i, c := range info.conns {
-		if c == conn {
-			info.conns = slices.Delete(info.conns, i, i+1)
+	for i, c := range info.clients {
+		if c == client {
+			info.clients = slices.Delete(info.clients, i, i+1)
 			break
 		}
 	}
 
-	if len(info.conns) == 0 {
+	if len(info.clients) == 0 {
 		delete(cm.connections, name)
 	} else {
 		cm.connections[name] = info
 	}
 }
 
-// BroadcastAll sends a message to all connected clients.
-func (cm *ConnectionManager) BroadcastAll(ctx context.Context, message []byte) {
-	cm.mutex.RLock()
-	var allConns []*websocket.Conn
-	var allNames []string
+// CloseAll stops all further broadcasts and closes every connection with a
+// going-away close frame, so clients see a clean disconnect during shutdown
+// and reconnect. Queued messages are discarded. It returns once every
+// close handshake has finished or timed out.
+func (cm *ConnectionManager) CloseAll() {
+	cm.mutex.Lock()
+	cm.closing = true
+	var clients []*wsClient
 	for _, info := range cm.connections {
-		for _, conn := range info.conns {
-			allConns = append(allConns, conn)
-			allNames = append(allNames, info.name)
-		}
+		clients = append(clients, info.clients...)
 	}
-	cm.mutex.RUnlock()
+	cm.mutex.Unlock()
 
 	var wg sync.WaitGroup
-	for i, conn := range allConns {
+	for _, c := range clients {
+		c.closing.Store(true)
 		wg.Add(1)
-		go func(conn *websocket.Conn, name string) {
+		go func() {
 			defer wg.Done()
-			writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			defer cancel()
-			if err := conn.Write(writeCtx, websocket.MessageText, message); err != nil {
-				slog.Warn("broadcast write failed", "client", name, "err", err)
-			}
-		}(conn, allNames[i])
+			_ = c.conn.Close(websocket.StatusGoingAway, "server shutting down")
+		}()
 	}
 	wg.Wait()
+	if len(clients) > 0 {
+		slog.Info("closed WebSocket connections", "count", len(clients))
+	}
+}
+
+// BroadcastAll queues a message for all connected clients, each of which
+// receives it in its negotiated encoding.
+func (cm *ConnectionManager) BroadcastAll(kind frameKind, message *wsMessage) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	for _, info := range cm.connections {
+		for _, c := range info.clients {
+			c.enqueue(frame{kind: kind, msg: message})
+		}
+	}
+}
+
+// Broadcast queues a message for the clients in a single room.
+func (cm *ConnectionManager) Broadcast(name string, kind frameKind, message *wsMessage) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	for _, c := range cm.connections[name].clients {
+		c.enqueue(frame{kind: kind, msg: message})
+	}
+}
+
+// BroadcastUpdate queues an update for the clients in a room: delta for
+// clients that negotiated deltas, snapshot for the rest. A nil delta sends
+// the snapshot to everyone.
+func (cm *ConnectionManager) BroadcastUpdate(name string, snapshot, delta *wsMessage) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	for _, c := range cm.connections[name].clients {
+		if delta != nil && c.supports(capabilityDelta) {
+			c.enqueue(frame{kind: frameDelta, msg: delta})
+		} else {
+			c.enqueue(frame{kind: frameSnapshot, msg: snapshot})
+		}
+	}
+}
+
+// BroadcastCapable queues a message for the clients in a room that
+// negotiated capability.
+func (cm *ConnectionManager) BroadcastCapable(name, capability string, kind frameKind, message *wsMessage) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	for _, c := range cm.connections[name].clients {
+		if c.supports(capability) {
+			c.enqueue(frame{kind: kind, msg: message})
+		}
+	}
+}
+
+// Rooms returns the names of all rooms with at least one client.
+func (cm *ConnectionManager) Rooms() []string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	rooms := make([]string, 0, len(cm.connections))
+	for name := range cm.connections {
+		rooms = append(rooms, name)
+	}
+	return rooms
 }
 
 func (cm *ConnectionManager) Count() int {
@@ -95,7 +400,7 @@ func (cm *ConnectionManager) Count() int {
 	defer cm.mutex.RUnlock()
 	count := 0
 	for _, info := range cm.connections {
-		count += len(info.conns)
+		count += len(info.clients)
 	}
 	return count
 }
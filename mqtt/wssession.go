@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// protocolVersion is the WebSocket message schema version spoken by the
+// server.
+const protocolVersion = 1
+
+// Capabilities a client may request in its hello.
+const (
+	// capabilityDelta sends single-device "device" and "remove" messages
+	// instead of a full snapshot after each update.
+	capabilityDelta = "delta"
+	// capabilityMsgpack sends every server message as a binary MessagePack
+	// frame instead of a text JSON frame.
+	capabilityMsgpack = "msgpack"
+	// capabilityInterpolate sends estimated "positions" of moving devices
+	// between real fixes, when interpolation is enabled.
+	capabilityInterpolate = "interpolate"
+)
+
+// serverCapabilities lists every capability the server can provide.
+var serverCapabilities = []string{capabilityDelta, capabilityMsgpack, capabilityInterpolate}
+
+// clientCommand is a message sent by a browser over the WebSocket.
+//
+//	{"type":"hello","version":1,"capabilities":["delta","msgpack"]}
+//	{"type":"subscribe","channel":"LongFast","tag":"team1","bbox":[minLon,minLat,maxLon,maxLat]}
+//	{"type":"viewport","bounds":{"west":14.4,"south":46.0,"east":14.6,"north":46.1}}
+//
+// Hello negotiates capabilities; clients that never send one only receive
+// full snapshots. Subscribe replaces the client's filter; omitted fields
+// match everything, so {"type":"subscribe"} restores the full feed.
+// Viewport only replaces the bounding box, for maps that report the visible
+// area as it is panned; omitted bounds clear it. Re-sending either renews
+// the filter's TTL.
+type clientCommand struct {
+	Type         string          `json:"type"`
+	Version      int             `json:"version"`
+	Capabilities []string        `json:"capabilities"`
+	Channel      string          `json:"channel"`
+	Tag          string          `json:"tag"`
+	BBox         []float64       `json:"bbox"`
+	Bounds       *viewportBounds `json:"bounds"`
+}
+
+// viewportBounds is the visible map area sent with a viewport command, in
+// degrees.
+type viewportBounds struct {
+	West  float64 `json:"west"`
+	South float64 `json:"south"`
+	East  float64 `json:"east"`
+	North float64 `json:"north"`
+}
+
+// bbox returns the bounds as a bounding box. Maps zoomed out past the whole
+// world report bounds beyond ±180° longitude, which are clamped.
+func (b viewportBounds) bbox() (bbox, error) {
+	return newBBox([]float64{
+		max(b.West, -180), max(b.South, -90),
+		min(b.East, 180), min(b.North, 90),
+	})
+}
+
+// WelcomeMessage answers a hello with the protocol version and the
+// capabilities the server will use for this client.
+type WelcomeMessage struct {
+	Type string      `json:"type"`
+	Data welcomeView `json:"data"`
+}
+
+type welcomeView struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// FilterMessage tells a client which filter is in effect, after a subscribe
+// and when a filter expires.
+type FilterMessage struct {
+	Type string     `json:"type"`
+	Data filterView `json:"data"`
+}
+
+type filterView struct {
+	Channel        string     `json:"channel"`
+	Tag            string     `json:"tag"`
+	BBox           []float64  `json:"bbox,omitempty"`
+	IncludeOffline bool       `json:"include_offline"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// wsSession tracks the filter of one WebSocket client and keeps it in the
+// matching room.
+type wsSession struct {
+	cm       *ConnectionManager
+	sub      *Subscriber
+	client   *wsClient
+	ttl      time.Duration
+	throttle time.Duration
+	// excludeOffline is set for the connection and kept across filter
+	// changes.
+	excludeOffline bool
+
+	mu           sync.Mutex
+	filter       deviceFilter
+	expiry       *time.Timer
+	refresh      *time.Timer
+	lastSnapshot time.Time
+	closed       bool
+}
+
+func newWSSession(cm *ConnectionManager, sub *Subscriber, client *wsClient, filter deviceFilter, ttl, throttle time.Duration) *wsSession {
+	s := &wsSession{cm: cm, sub: sub, client: client, ttl: ttl, throttle: throttle, filter: filter, excludeOffline: filter.ExcludeOffline}
+	cm.Add(filter.room(), client)
+	return s
+}
+
+// handle applies a command received from the client.
+func (s *wsSession) handle(ctx context.Context, data []byte) {
+	var cmd clientCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		slog.Debug("ignoring invalid WebSocket command", "client", s.client.id, "err", err)
+		return
+	}
+	switch cmd.Type {
+	case "hello":
+		s.hello(ctx, cmd)
+	case "subscribe":
+		box, err := newBBox(cmd.BBox)
+		if err != nil {
+			slog.Debug("ignoring invalid WebSocket filter", "client", s.client.id, "err", err)
+			return
+		}
+		s.setFilter(ctx, deviceFilter{Channel: cmd.Channel, Tag: cmd.Tag, BBox: box, ExcludeOffline: s.excludeOffline}, true)
+	case "viewport":
+		var box bbox
+		if cmd.Bounds != nil {
+			var err error
+			if box, err = cmd.Bounds.bbox(); err != nil {
+				slog.Debug("ignoring invalid WebSocket viewport", "client", s.client.id, "err", err)
+				return
+			}
+		}
+		s.mu.Lock()
+		f := s.filter
+		s.mu.Unlock()
+		f.BBox = box
+		s.setFilter(ctx, f, true)
+	default:
+		slog.Debug("ignoring unknown WebSocket command", "client", s.client.id, "type", cmd.Type)
+	}
+}
+
+// setFilter moves the client to the room for f and queues a matching
+// snapshot, throttled so that rapid changes such as panning the map only
+// produce one. Filters set by a command expire after the TTL unless renewed.
+func (s *wsSession) setFilter(ctx context.Context, f deviceFilter, expires bool) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if old := s.filter.room(); old != f.room() {
+		s.cm.Remove(old, s.client)
+		s.cm.Add(f.room(), s.client)
+	}
+	s.filter = f
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	var expiresAt *time.Time
+	if expires && s.ttl > 0 && f != (deviceFilter{ExcludeOffline: s.excludeOffline}) {
+		t := time.Now().Add(s.ttl).UTC()
+		expiresAt = &t
+		s.expiry = time.AfterFunc(s.ttl, func() {
+			slog.Info("WebSocket filter expired", "client", s.client.id)
+			s.setFilter(ctx, deviceFilter{ExcludeOffline: s.excludeOffline}, false)
+		})
+	}
+	s.mu.Unlock()
+
+	s.sendFilter(f, expiresAt)
+	s.queueSnapshot(ctx)
+}
+
+// queueSnapshot sends a snapshot for the current filter unless one was sent
+// within the throttle interval, in which case a single snapshot is scheduled
+// for the end of the interval. It picks up whatever filter is current when it
+// fires.
+func (s *wsSession) queueSnapshot(ctx context.Context) {
+	s.mu.Lock()
+	if s.closed || s.refresh != nil {
+		s.mu.Unlock()
+		return
+	}
+	if wait := s.throttle - time.Since(s.lastSnapshot); wait > 0 {
+		s.refresh = time.AfterFunc(wait, func() {
+			s.mu.Lock()
+			s.refresh = nil
+			s.mu.Unlock()
+			s.sendSnapshot(ctx)
+		})
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.sendSnapshot(ctx)
+}
+
+// sendSnapshot queues a snapshot for the current filter.
+func (s *wsSession) sendSnapshot(ctx context.Context) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	filter := s.filter
+	s.lastSnapshot = time.Now()
+	s.mu.Unlock()
+
+	snapshot, err := s.sub.LoadAndBroadcast(ctx, filter)
+	if err != nil {
+		slog.Error("failed to load devices for filter", "err", err)
+		return
+	}
+	s.client.enqueue(frame{kind: frameSnapshot, msg: snapshot})
+}
+
+// hello enables the requested capabilities the server supports and replies
+// with a welcome followed by a fresh snapshot, so the client starts from a
+// known state in the negotiated format.
+func (s *wsSession) hello(ctx context.Context, cmd clientCommand) {
+	caps := []string{}
+	for _, c := range cmd.Capabilities {
+		if slices.Contains(serverCapabilities, c) && !slices.Contains(caps, c) {
+			caps = append(caps, c)
+		}
+	}
+	s.client.setCapabilities(caps)
+	slog.Debug("WebSocket hello", "client", s.client.id, "version", cmd.Version, "capabilities", caps)
+
+	msg, err := newWSMessage(WelcomeMessage{
+		Type: "welcome",
+		Data: welcomeView{Version: protocolVersion, Capabilities: caps},
+	})
+	if err != nil {
+		slog.Error("failed to marshal welcome message", "err", err)
+		return
+	}
+	s.client.enqueue(frame{kind: frameEvent, msg: msg})
+	s.sendSnapshot(ctx)
+}
+
+func (s *wsSession) sendFilter(f deviceFilter, expiresAt *time.Time) {
+	view := filterView{Channel: f.Channel, Tag: f.Tag, IncludeOffline: !f.ExcludeOffline, ExpiresAt: expiresAt}
+	if f.BBox != (bbox{}) {
+		view.BBox = []float64{f.BBox.MinLon, f.BBox.MinLat, f.BBox.MaxLon, f.BBox.MaxLat}
+	}
+	msg, err := newWSMessage(FilterMessage{Type: "filter", Data: view})
+	if err != nil {
+		slog.Error("failed to marshal filter message", "err", err)
+		return
+	}
+	s.client.enqueue(frame{kind: frameEvent, msg: msg})
+}
+
+// close removes the client from its room and stops any pending expiry or
+// refresh.
+func (s *wsSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	if s.refresh != nil {
+		s.refresh.Stop()
+	}
+	s.cm.Remove(s.filter.room(), s.client)
+}
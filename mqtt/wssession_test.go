@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestViewportBoundsBBox(t *testing.T) {
+	tests := []struct {
+		name    string
+		bounds  viewportBounds
+		want    bbox
+		wantErr bool
+	}{
+		{"inside", viewportBounds{West: -1, South: 51, East: 1, North: 52}, bbox{MinLon: -1, MinLat: 51, MaxLon: 1, MaxLat: 52}, false},
+		{"whole world", viewportBounds{West: -180, South: -90, East: 180, North: 90}, bbox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}, false},
+		{"zoomed out past the world", viewportBounds{West: -540, South: -95, East: 540, North: 95}, bbox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}, false},
+		{"inverted", viewportBounds{West: 1, South: 52, East: -1, North: 51}, bbox{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.bounds.bbox()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bbox() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("bbox() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
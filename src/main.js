@@ -156,7 +156,8 @@ function buildInfoContent(device) {
   title.className = "card-heading";
   title.style.cssText =
     "font-weight:700; font-size:15px; color:var(--color-site-text);";
-  title.textContent = device.id;
+  title.textContent = device.display_name || device.id;
+  if (device.long_name) title.title = `${device.long_name} (${device.id})`;
 
   const status = document.createElement("div");
   status.className = device.online ? "status-online" : "status-offline";
@@ -182,6 +183,20 @@ function buildInfoContent(device) {
     ["Speed", device.speed ? device.speed.toFixed(1) + " km/h" : "0.0 km/h"],
     ["Sats", `${device.sats || 0}`],
   ];
+  if (device.gateway) {
+    rows.push(["Signal", `${device.snr} dB via ${device.gateway}`]);
+  }
+  if (device.tags && device.tags.length) {
+    rows.push(["Tags", device.tags.join(", ")]);
+  }
+  if (device.override) {
+    rows.push(["Position", "Pinned"]);
+  }
+  if (device.class === "sensor") {
+    rows.push(["Type", "Sensor"]);
+  } else if (device.class === "infrastructure") {
+    rows.push(["Type", "Infrastructure"]);
+  }
 
   rows.forEach(([label, value]) => {
     const row = document.createElement("div");
@@ -321,10 +336,25 @@ function renderDevices() {
 // --- WebSocket ---
 function connectWebSocket() {
   const proto = window.location.protocol === "https:" ? "wss:" : "ws:";
-  const ws = new ReconnectingWebSocket(`${proto}//${window.location.host}/ws`);
+  // Forward the page's device filters (?channel=, ?tag=, ?include_offline=)
+  // to the feed.
+  const pageParams = new URLSearchParams(window.location.search);
+  const params = new URLSearchParams();
+  for (const key of ["channel", "tag", "include_offline"]) {
+    const value = pageParams.get(key);
+    if (value) params.set(key, value);
+  }
+  const query = params.size ? `?${params}` : "";
+  const ws = new ReconnectingWebSocket(
+    `${proto}//${window.location.host}/ws${query}`,
+  );
   const statusEl = document.getElementById("ws-status");
 
   ws.addEventListener("open", () => {
+    // Ask for single-device updates instead of a snapshot per change.
+    ws.send(
+      JSON.stringify({ type: "hello", version: 1, capabilities: ["delta"] }),
+    );
     statusEl.textContent = "● Connected";
     statusEl.style.color = "var(--color-site-online, #28a745)";
   });
@@ -345,6 +375,17 @@ function connectWebSocket() {
           devices[d.id] = d;
         });
         renderDevices();
+        if (msg.truncated) loadRemainingDevices(query);
+      } else if (msg.type === "device") {
+        devices[msg.data.id] = msg.data;
+        renderDevices();
+      } else if (msg.type === "remove") {
+        delete devices[msg.id];
+        renderDevices();
+      } else if (msg.type === "viewers") {
+        const viewersEl = document.getElementById("viewers");
+        viewersEl.textContent = `${msg.count} watching`;
+        viewersEl.classList.remove("hidden");
       }
     } catch (e) {
       console.error("WS parse error", e);
@@ -352,6 +393,22 @@ function connectWebSocket() {
   });
 }
 
+// A snapshot cut by -snapshot-limit only holds the most recently seen
+// devices; fetch the rest over REST. Devices already known are newer than
+// the list, so they are kept.
+async function loadRemainingDevices(query) {
+  try {
+    const resp = await fetch(`/api/devices${query}`);
+    if (!resp.ok) throw new Error(`HTTP ${resp.status}`);
+    (await resp.json()).forEach((d) => {
+      if (!devices[d.id]) devices[d.id] = d;
+    });
+    renderDevices();
+  } catch (e) {
+    console.error("failed to load remaining devices", e);
+  }
+}
+
 // --- Clock ---
 function startClock() {
   const el = document.getElementById("clock");